@@ -0,0 +1,212 @@
+// 消息桥接。通过CmdSet中间件订阅指定的消息，发布到外部消息队列
+// 1、消息处理后异步发布，外部消息队列异常时不阻塞业务处理
+// 2、发布队列满时丢弃消息，关闭时等待队列中的消息发布完成
+// 3、NATS、Kafka需使用构建标签nats、kafka编译
+
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/log"
+)
+
+const (
+	defaultTopicPrefix    = "quasar."
+	defaultQueueSize      = 4 << 10
+	defaultPublishTimeout = 5 * time.Second
+)
+
+var errBridgeClosed = errors.New("bridge is closed")
+
+// 发布到外部消息队列的消息
+type Message struct {
+	Topic string
+	Key   string // 分区键，用户ID或会话ID
+	Data  []byte
+}
+
+// 外部消息队列
+type Broker interface {
+	Publish(ctx context.Context, msg *Message) error
+	Close() error
+}
+
+// 消息内容
+type Event struct {
+	Id         string          `json:"id"`
+	Data       json.RawMessage `json:"data,omitempty"`
+	Ssid       string          `json:"ssid,omitempty"`
+	UserId     string          `json:"userId,omitempty"`
+	ServerName string          `json:"serverName,omitempty"`
+	ClientAddr string          `json:"clientAddr,omitempty"`
+	Ts         int64           `json:"ts"` // 毫秒
+}
+
+type Options struct {
+	MsgIds         []string      // 订阅的消息ID，支持前缀匹配，如c2s_pay*
+	TopicPrefix    string        // 主题为前缀+消息ID，默认quasar.
+	QueueSize      int           // 发布队列长度，默认4096
+	PublishTimeout time.Duration // 单个消息发布超时，默认5s
+}
+
+type Stats struct {
+	Published int64 `json:"published"`
+	Dropped   int64 `json:"dropped"` // 队列满时丢弃的消息数
+	Failed    int64 `json:"failed"`  // 发布失败的消息数
+}
+
+type Bridge struct {
+	broker Broker
+	opts   Options
+
+	msgIds   map[string]bool
+	prefixes []string
+
+	queue   chan *Message
+	mu      sync.RWMutex
+	isClose bool
+	done    chan struct{}
+
+	published, dropped, failed atomic.Int64
+}
+
+func New(broker Broker, opts Options) *Bridge {
+	b := &Bridge{
+		broker: broker,
+		opts:   opts,
+		msgIds: map[string]bool{},
+		done:   make(chan struct{}),
+	}
+	if b.opts.TopicPrefix == "" {
+		b.opts.TopicPrefix = defaultTopicPrefix
+	}
+	if b.opts.QueueSize <= 0 {
+		b.opts.QueueSize = defaultQueueSize
+	}
+	if b.opts.PublishTimeout <= 0 {
+		b.opts.PublishTimeout = defaultPublishTimeout
+	}
+	for _, msgId := range opts.MsgIds {
+		msgId = strings.ToLower(msgId)
+		if prefix, ok := strings.CutSuffix(msgId, "*"); ok {
+			b.prefixes = append(b.prefixes, prefix)
+		} else {
+			b.msgIds[msgId] = true
+		}
+	}
+	b.queue = make(chan *Message, b.opts.QueueSize)
+	go b.run()
+	return b
+}
+
+// 订阅的消息
+func (b *Bridge) match(msgId string) bool {
+	if b.msgIds[msgId] {
+		return true
+	}
+	for _, prefix := range b.prefixes {
+		if strings.HasPrefix(msgId, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// 添加到CmdSet：set.Use(b.Middleware())
+func (b *Bridge) Middleware() cmd.Middleware {
+	return func(next cmd.Handler) cmd.Handler {
+		return func(ctx *cmd.Context, args any) {
+			if !b.match(ctx.MsgId) {
+				next(ctx, args)
+				return
+			}
+			// 处理前编码，避免业务修改参数
+			data, err := json.Marshal(args)
+			next(ctx, args)
+			if err != nil {
+				log.Warnf("bridge encode message %s %v", ctx.MsgId, err)
+				return
+			}
+			event := &Event{
+				Id:         ctx.MsgId,
+				Data:       data,
+				Ssid:       ctx.Ssid,
+				UserId:     ctx.UserId,
+				ServerName: ctx.ServerName,
+				ClientAddr: ctx.ClientAddr,
+				Ts:         time.Now().UnixMilli(),
+			}
+			b.Publish(event)
+		}
+	}
+}
+
+// 发布消息，队列满时丢弃
+func (b *Bridge) Publish(event *Event) error {
+	buf, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	key := event.UserId
+	if key == "" {
+		key = event.Ssid
+	}
+	msg := &Message{Topic: b.opts.TopicPrefix + event.Id, Key: key, Data: buf}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.isClose {
+		return errBridgeClosed
+	}
+	select {
+	case b.queue <- msg:
+	default:
+		b.dropped.Add(1)
+		log.Warnf("bridge queue is full, drop message %s", event.Id)
+	}
+	return nil
+}
+
+func (b *Bridge) run() {
+	defer close(b.done)
+	for msg := range b.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), b.opts.PublishTimeout)
+		err := b.broker.Publish(ctx, msg)
+		cancel()
+		if err != nil {
+			b.failed.Add(1)
+			log.Errorf("bridge publish %s %v", msg.Topic, err)
+			continue
+		}
+		b.published.Add(1)
+	}
+}
+
+func (b *Bridge) Stats() Stats {
+	return Stats{
+		Published: b.published.Load(),
+		Dropped:   b.dropped.Load(),
+		Failed:    b.failed.Load(),
+	}
+}
+
+// 发布队列中剩余的消息后关闭外部消息队列
+func (b *Bridge) Close() error {
+	b.mu.Lock()
+	if !b.isClose {
+		b.isClose = true
+		close(b.queue)
+	}
+	b.mu.Unlock()
+
+	<-b.done
+	return b.broker.Close()
+}
@@ -0,0 +1,64 @@
+package bridge_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/guogeer/quasar/v2/bridge"
+	"github.com/guogeer/quasar/v2/cmd"
+)
+
+type payArgs struct {
+	Gold int `json:"gold,omitempty"`
+}
+
+func TestBridge(t *testing.T) {
+	broker := bridge.NewMemoryBroker()
+	sub := broker.Subscribe("", 8)
+	b := bridge.New(broker, bridge.Options{MsgIds: []string{"c2s_pay*", "c2s_login"}})
+
+	set := cmd.NewCmdSet()
+	set.Use(b.Middleware())
+	var calls int
+	for _, msgId := range []string{"c2s_payOrder", "c2s_login", "c2s_chat"} {
+		set.Bind(msgId, func(ctx *cmd.Context, data any) {
+			calls++
+			data.(*payArgs).Gold = 0 // 修改参数不影响发布的消息
+		}, (*payArgs)(nil))
+	}
+
+	set.Handle(&cmd.Context{UserId: "1001"}, "c2s_payOrder", []byte(`{"gold":10}`))
+	set.Handle(&cmd.Context{Ssid: "ss1"}, "c2s_chat", []byte(`{"gold":1}`))
+	set.Handle(&cmd.Context{Ssid: "ss1"}, "c2s_login", nil)
+	if calls != 3 {
+		t.Errorf("handle %d messages", calls)
+	}
+
+	expects := []struct {
+		topic, key, data string
+	}{
+		{"quasar.c2s_payorder", "1001", `{"gold":10}`},
+		{"quasar.c2s_login", "ss1", `{}`},
+	}
+	for _, expect := range expects {
+		select {
+		case msg := <-sub:
+			event := &bridge.Event{}
+			json.Unmarshal(msg.Data, event)
+			if msg.Topic != expect.topic || msg.Key != expect.key || string(event.Data) != expect.data {
+				t.Errorf("publish %s %s %s", msg.Topic, msg.Key, event.Data)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("wait publish timeout")
+		}
+	}
+
+	b.Close()
+	if stats := b.Stats(); stats.Published != 2 || stats.Dropped != 0 {
+		t.Errorf("stats %+v", stats)
+	}
+	if _, ok := <-sub; ok {
+		t.Error("publish unsubscribed message")
+	}
+}
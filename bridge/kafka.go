@@ -0,0 +1,40 @@
+//go:build kafka
+
+package bridge
+
+// Kafka消息队列，使用构建标签kafka编译
+
+import (
+	"context"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type kafkaBroker struct {
+	w *kafka.Writer
+}
+
+// 连接Kafka，如127.0.0.1:9092。相同分区键的消息写入同一分区，保证同一用户的消息有序
+func NewKafkaBroker(addrs ...string) Broker {
+	w := &kafka.Writer{
+		Addr:                   kafka.TCP(addrs...),
+		Balancer:               &kafka.Hash{},
+		RequiredAcks:           kafka.RequireAll,
+		AllowAutoTopicCreation: true,
+		BatchTimeout:           10 * time.Millisecond, // 逐个发布，默认1s的批量等待过长
+	}
+	return &kafkaBroker{w: w}
+}
+
+func (kb *kafkaBroker) Publish(ctx context.Context, msg *Message) error {
+	return kb.w.WriteMessages(ctx, kafka.Message{
+		Topic: msg.Topic,
+		Key:   []byte(msg.Key),
+		Value: msg.Data,
+	})
+}
+
+func (kb *kafkaBroker) Close() error {
+	return kb.w.Close()
+}
@@ -0,0 +1,27 @@
+//go:build kafka
+
+package bridge_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/guogeer/quasar/v2/bridge"
+)
+
+func TestKafkaBroker(t *testing.T) {
+	broker := bridge.NewKafkaBroker("127.0.0.1:1")
+	defer broker.Close()
+
+	// 连接失败时发布出错，不阻塞超过ctx的时间
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := broker.Publish(ctx, &bridge.Message{Topic: "quasar.c2s_login", Key: "1001", Data: []byte("{}")}); err == nil {
+		t.Error("publish to unreachable kafka")
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("publish blocked %v", d)
+	}
+}
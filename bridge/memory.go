@@ -0,0 +1,63 @@
+package bridge
+
+// 进程内的消息队列，用于测试或单进程部署
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var errBrokerClosed = errors.New("broker is closed")
+
+type MemoryBroker struct {
+	mu      sync.RWMutex
+	subs    map[string][]chan *Message
+	isClose bool
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{subs: map[string][]chan *Message{}}
+}
+
+// 订阅主题，topic为空时订阅所有主题。订阅方未及时读取时发布阻塞
+func (mb *MemoryBroker) Subscribe(topic string, size int) <-chan *Message {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	ch := make(chan *Message, size)
+	mb.subs[topic] = append(mb.subs[topic], ch)
+	return ch
+}
+
+func (mb *MemoryBroker) Publish(ctx context.Context, msg *Message) error {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+	if mb.isClose {
+		return errBrokerClosed
+	}
+	for _, topic := range []string{msg.Topic, ""} {
+		for _, ch := range mb.subs[topic] {
+			select {
+			case ch <- msg:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// 关闭所有的订阅
+func (mb *MemoryBroker) Close() error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if !mb.isClose {
+		mb.isClose = true
+		for _, chs := range mb.subs {
+			for _, ch := range chs {
+				close(ch)
+			}
+		}
+	}
+	return nil
+}
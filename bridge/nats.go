@@ -0,0 +1,43 @@
+//go:build nats
+
+package bridge
+
+// NATS消息队列，使用构建标签nats编译
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+)
+
+type natsBroker struct {
+	nc *nats.Conn
+}
+
+// 连接NATS，如nats://127.0.0.1:4222。分区键写入消息头Quasar-Key
+func NewNATSBroker(url string, opts ...nats.Option) (Broker, error) {
+	nc, err := nats.Connect(url, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &natsBroker{nc: nc}, nil
+}
+
+func (nb *natsBroker) Publish(ctx context.Context, msg *Message) error {
+	m := nats.NewMsg(msg.Topic)
+	m.Data = msg.Data
+	if msg.Key != "" {
+		m.Header.Set("Quasar-Key", msg.Key)
+	}
+	return nb.nc.PublishMsg(m)
+}
+
+// 发送缓冲中的消息后关闭
+func (nb *natsBroker) Close() error {
+	if err := nb.nc.Flush(); err != nil {
+		nb.nc.Close()
+		return err
+	}
+	nb.nc.Close()
+	return nil
+}
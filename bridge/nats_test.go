@@ -0,0 +1,20 @@
+//go:build nats
+
+package bridge_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/guogeer/quasar/v2/bridge"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestNATSBroker(t *testing.T) {
+	broker, err := bridge.NewNATSBroker("nats://127.0.0.1:1", nats.Timeout(time.Second), nats.MaxReconnects(0))
+	if err == nil {
+		broker.Close()
+		t.Error("connect unreachable nats")
+	}
+}
@@ -0,0 +1,107 @@
+package cmd
+
+// 网关与客户端之间紧凑的二进制协议，通过WebSocket二进制帧传输
+// 消息格式：uvarint(len(id)) id uvarint(len(sign)) sign uvarint(len(data)) data
+// 网关下发时一帧可连续包含多条消息，客户端上行时一帧一条消息
+// 签名：md5(key+id+data)，同JSON协议按ref取部分字符
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+)
+
+var errInvalidBinary = errors.New("invalid binary package")
+
+func appendBinaryField(dst, field []byte) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(field)))
+	return append(dst, field...)
+}
+
+func readBinaryField(buf []byte) ([]byte, []byte, error) {
+	n, size := binary.Uvarint(buf)
+	if size <= 0 || uint64(len(buf)-size) < n {
+		return nil, nil, errInvalidBinary
+	}
+	buf = buf[size:]
+	return buf[:n], buf[n:], nil
+}
+
+func (codec *hashCodec) binarySign(id string, data []byte) string {
+	if codec.key == "" {
+		return ""
+	}
+	buf := make([]byte, 0, len(codec.key)+len(id)+len(data))
+	buf = append(buf, codec.key...)
+	buf = append(buf, id...)
+	buf = append(buf, data...)
+	sum := md5.Sum(buf)
+	sign := hex.EncodeToString(sum[:])
+	if ref := codec.ref; len(ref) == len(codec.tempSign) {
+		sign2 := make([]byte, len(ref))
+		for k, v := range ref {
+			sign2[k] = sign[v]
+		}
+		sign = string(sign2)
+	}
+	return sign
+}
+
+// 追加一条二进制消息，下发客户端的消息不签名
+func AppendBinary(dst []byte, pkg *Package) ([]byte, error) {
+	data := []byte(pkg.Data)
+	if pkg.Body != nil {
+		buf, err := marshalJSON(pkg.Body)
+		if err != nil {
+			return nil, err
+		}
+		data = buf
+	}
+	dst = appendBinaryField(dst, []byte(pkg.Id))
+	dst = appendBinaryField(dst, nil)
+	dst = appendBinaryField(dst, data)
+	return dst, nil
+}
+
+// 客户端编码二进制消息
+func EncodeBinary(name string, i any) ([]byte, error) {
+	data, err := marshalJSON(i)
+	if err != nil {
+		return nil, err
+	}
+	var buf []byte
+	buf = appendBinaryField(buf, []byte(name))
+	buf = appendBinaryField(buf, []byte(clientCodec.Load().binarySign(name, data)))
+	buf = appendBinaryField(buf, data)
+	return buf, nil
+}
+
+// 解析客户端上行的二进制消息并校验签名
+func DecodeBinary(buf []byte) (*Package, error) {
+	id, buf, err := readBinaryField(buf)
+	if err != nil {
+		return nil, err
+	}
+	sign, buf, err := readBinaryField(buf)
+	if err != nil {
+		return nil, err
+	}
+	data, buf, err := readBinaryField(buf)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > 0 {
+		return nil, errInvalidBinary
+	}
+
+	pkg := &Package{Id: string(id), Sign: string(sign)}
+	if len(data) > 0 {
+		pkg.Data = append(json.RawMessage(nil), data...)
+	}
+	if expect := clientCodec.Load().binarySign(pkg.Id, pkg.Data); expect != "" && expect != pkg.Sign {
+		return pkg, ErrInvalidSign
+	}
+	return pkg, nil
+}
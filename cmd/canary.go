@@ -0,0 +1,52 @@
+package cmd
+
+// 灰度路由规则
+// 匹配标签的服务为灰度服务，仅接收规则选中的会话：指定的用户或按百分比选择
+// 未选中的会话优先匹配非灰度的服务。规则由路由保存并同步到网关
+
+import (
+	"hash/crc32"
+	"slices"
+)
+
+type RouteRule struct {
+	Name    string            `json:"name,omitempty" binding:"required"`         // 服务名，如hall
+	Labels  map[string]string `json:"labels,omitempty" binding:"required"`       // 灰度服务的标签，如{"version":"1.2.0"}
+	Percent int               `json:"percent,omitempty" binding:"min=0,max=100"` // 选中会话的百分比
+	UserIds []string          `json:"userIds,omitempty"`                         // 选中的用户
+}
+
+// 服务的标签包含规则的所有标签
+func (rule *RouteRule) MatchLabels(labels map[string]string) bool {
+	if len(rule.Labels) == 0 {
+		return false
+	}
+	for k, v := range rule.Labels {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// 是否选中会话。key为会话固定的选择键，按key计算百分比，会话期间需保持不变
+// 网关首次匹配服务时确定key：已认证为用户ID，否则为会话ID
+func (rule *RouteRule) Select(key, userId string) bool {
+	if userId != "" && slices.Contains(rule.UserIds, userId) {
+		return true
+	}
+	return int(crc32.ChecksumIEEE([]byte(rule.Name+":"+key))%100) < rule.Percent
+}
+
+type routeRulesArgs struct {
+	Rules []RouteRule `json:"rules" binding:"dive"`
+}
+
+// 替换路由的灰度规则，规则为空时取消灰度
+func SetRouteRules(rules []RouteRule) {
+	defaultCmdSet.SetRouteRules(rules)
+}
+
+func (s *CmdSet) SetRouteRules(rules []RouteRule) {
+	s.Route("router", "c2s_setRouteRules", &routeRulesArgs{Rules: rules})
+}
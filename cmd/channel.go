@@ -0,0 +1,43 @@
+package cmd
+
+// 频道订阅。会话加入的频道保存在网关，发布的消息经路由转发到有订阅的网关
+// 会话断开后网关自动退出频道
+
+import "errors"
+
+type channelArgs struct {
+	Channel string `json:"channel,omitempty"`
+	MsgId   string `json:"msgId,omitempty"`
+	MsgData any    `json:"msgData,omitempty"`
+}
+
+// 通知网关修改会话的频道
+func (ctx *Context) writeChannel(msgId, channel string) error {
+	if ctx.Out == nil || ctx.Ssid == "" {
+		return errors.New("channel require client session")
+	}
+	pkg := &Package{Id: msgId, Ssid: ctx.Ssid, Body: channelArgs{Channel: channel}}
+	buf, err := EncodePackage(pkg)
+	if err != nil {
+		return err
+	}
+	return ctx.Out.Write(buf)
+}
+
+// 发送消息的客户端加入频道，仅处理经网关转发的消息时有效
+func (ctx *Context) JoinChannel(channel string) error {
+	return ctx.writeChannel("func_joinChannel", channel)
+}
+
+func (ctx *Context) LeaveChannel(channel string) error {
+	return ctx.writeChannel("func_leaveChannel", channel)
+}
+
+// 发布消息到频道的所有会话
+func (s *CmdSet) Publish(channel, msgId string, msgData any) {
+	s.Route("router", "c2s_publish", &channelArgs{Channel: channel, MsgId: msgId, MsgData: msgData})
+}
+
+func Publish(channel, msgId string, msgData any) {
+	defaultCmdSet.Publish(channel, msgId, msgData)
+}
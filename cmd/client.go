@@ -3,24 +3,25 @@ package cmd
 import (
 	"context"
 	"net"
-	"sync"
 	"time"
 
 	"github.com/guogeer/quasar/v2/log"
 )
 
-var clients sync.Map // 已存在的连接不会被删除
-
 type Client struct {
 	TCPConn
 
 	serverId string
 	conf     ServiceConfig // 向路由注册的参数
+	set      *CmdSet       // 处理收到的消息
+	outbox   *outbox       // 待确认的可靠消息
 }
 
-func newClient(serverId string) *Client {
+func newClient(set *CmdSet, serverId string) *Client {
 	client := &Client{
 		serverId: serverId,
+		set:      set,
+		outbox:   newOutbox(set.reliable.outboxMemLimit, set.outboxSpillPath(serverId)),
 		TCPConn: TCPConn{
 			send: make(chan []byte, sendQueueSize),
 		},
@@ -33,6 +34,9 @@ func (client *Client) connect() {
 
 	internalMillis := []int{100, 400, 1600, 3200, 5000}
 	for retry := 0; true; retry++ {
+		if client.set.isClosed.Load() {
+			return
+		}
 		// 间隔时间
 		ms := internalMillis[len(internalMillis)-1]
 		if retry < len(internalMillis) {
@@ -40,7 +44,7 @@ func (client *Client) connect() {
 		}
 
 		// 第一步向路由查询地址
-		addr, err := RequestServerAddr(serverId)
+		addr, err := client.set.RequestServerAddr(serverId)
 		if err != nil {
 			log.Infof("connect %s %v", serverId, err)
 		}
@@ -49,7 +53,9 @@ func (client *Client) connect() {
 		if addr != "" {
 			rwc, err := net.Dial("tcp", addr)
 			if err == nil {
+				client.mu.Lock()
 				client.rwc = rwc
+				client.mu.Unlock()
 				break
 			}
 		}
@@ -64,8 +70,10 @@ func (c *Client) start() {
 	doneCtx, cancel := context.WithCancel(context.Background())
 	go func() {
 		ticker := time.NewTicker(pingPeriod)
+		resendTicker := time.NewTicker(reliableResendPeriod)
 		defer func() {
 			ticker.Stop() // 关闭定时器
+			resendTicker.Stop()
 			c.rwc.Close() // 关闭连接
 
 			// 关闭后，自动重连，并消息通知
@@ -76,10 +84,14 @@ func (c *Client) start() {
 		pkg := &Package{
 			Ts: time.Now().Unix(),
 		}
-		firstMsg, _ := authCodec.Encode(pkg)
+		firstMsg, _ := authCodec.Load().Encode(pkg)
 		if _, err := c.writeMsg(RawMessage, firstMsg); err != nil {
 			return
 		}
+		// 重连后重发未确认的消息
+		if err := c.resendReliable(true); err != nil {
+			return
+		}
 		for {
 			select {
 			case buf, ok := <-c.send:
@@ -93,6 +105,10 @@ func (c *Client) start() {
 				if _, err := c.writeMsg(PingMessage, nil); err != nil {
 					return
 				}
+			case <-resendTicker.C:
+				if err := c.resendReliable(false); err != nil {
+					return
+				}
 			case <-doneCtx.Done():
 				return
 			}
@@ -116,7 +132,8 @@ func (c *Client) start() {
 			}
 
 			id, ssid, data := pkg.Id, pkg.Ssid, pkg.Data
-			err = defaultCmdSet.Handle(&Context{Out: c, Ssid: ssid}, id, data)
+			ctx := &Context{Out: c, Ssid: ssid, UserId: pkg.UserId, seq: pkg.Seq, acked: pkg.Acked, sender: pkg.Sender, replyTo: pkg.ReplyTo}
+			err = c.set.Handle(ctx, id, data)
 			if err != nil {
 				log.Debugf("handle message[%s] %v", id, err)
 			}
@@ -124,15 +141,16 @@ func (c *Client) start() {
 	}
 }
 
-func routeMsg(serverId string, data []byte) {
+// 向服务的连接，不存在时新建并连接
+func (s *CmdSet) client(serverId string) *Client {
 	if serverId == "" {
 		panic("route empty server")
 	}
 
-	client, ok := clients.Load(serverId)
+	client, ok := s.clients.Load(serverId)
 	if !ok {
-		newClient := newClient(serverId)
-		client, ok = clients.LoadOrStore(serverId, newClient)
+		newClient := newClient(s, serverId)
+		client, ok = s.clients.LoadOrStore(serverId, newClient)
 		// 防止重复连接
 		if ok {
 			close(newClient.send)
@@ -142,41 +160,75 @@ func routeMsg(serverId string, data []byte) {
 			}()
 		}
 	}
+	return client.(*Client)
+}
 
-	if err := client.(*Client).Write(data); err != nil {
+func (s *CmdSet) routeMsg(serverId string, data []byte) {
+	s.recordOut(serverId, data)
+	if s.isReplaying.Load() {
+		return
+	}
+	if err := s.client(serverId).Write(data); err != nil {
 		log.Errorf("server %s write %s error: %v", serverId, data, err)
 	}
 }
 
 func Route(serverId, msgId string, i any) {
+	defaultCmdSet.Route(serverId, msgId, i)
+}
+
+func (s *CmdSet) Route(serverId, msgId string, i any) {
 	pkg := &Package{Id: msgId, Body: i}
 	buf, err := EncodePackage(pkg)
 	if err != nil {
 		return
 	}
-	routeMsg(serverId, buf)
+	s.routeMsg(serverId, buf)
 }
 
 // 向router注册服务
 func RegisterService(conf *ServiceConfig) {
+	defaultCmdSet.RegisterService(conf)
+}
+
+func (s *CmdSet) RegisterService(conf *ServiceConfig) {
 	if conf.Id == "" {
 		conf.Id = conf.Name
 	}
 	if conf.Id == "" {
 		panic("empty server id")
 	}
-	Route("router", "c2s_register", conf)
+	s.bindHealthCheck()
+	s.Route("router", "c2s_register", conf)
 
-	client, _ := clients.Load("router")
+	client, _ := s.clients.Load("router")
 	client.(*Client).conf = *conf
 }
 
 // Client自动重连
 func (client *Client) autoConnect() {
+	if client.set.isClosed.Load() {
+		return
+	}
 	if client.serverId == "router" {
-		RegisterService(&client.conf)
+		client.set.RegisterService(&client.conf)
 	}
 	go func() {
 		client.connect()
 	}()
 }
+
+// 断开与其他服务的连接，不再重连。用于测试等需在进程内停止服务的场景
+func (s *CmdSet) Close() {
+	s.isClosed.Store(true)
+	s.clients.Range(func(_, v any) bool {
+		client := v.(*Client)
+		client.mu.Lock()
+		rwc := client.rwc
+		client.mu.Unlock()
+		if rwc != nil {
+			rwc.Close()
+		}
+		return true
+	})
+}
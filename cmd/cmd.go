@@ -7,6 +7,7 @@ import (
 	"reflect"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/guogeer/quasar/v2/config"
@@ -14,24 +15,31 @@ import (
 )
 
 var (
-	enableDebug       = false
-	defaultRouterAddr = "127.0.0.1:9003"
+	enableDebug       atomic.Bool
+	defaultRouterAddr atomic.Value
 )
 
+// 配置加载后更新路由地址等，导入包时不读取配置
 func init() {
-	conf := config.Config()
+	defaultRouterAddr.Store("127.0.0.1:9003")
+	config.OnChange(applyConfig)
+}
+
+// 配置热更新。校验KEY修改后重建协议，各服务需同时更新
+func applyConfig(oldConf, newConf *config.Env) {
 	// 服务器内部数据校验KEY
-	if conf.ClientKey != "" {
-		authCodec.key = conf.ClientKey
-		clientCodec.key = conf.ClientKey
+	if oldConf.ClientKey != newConf.ClientKey {
+		setCodecKey(newConf.ClientKey)
 	}
-	if conf.EnableDebug {
-		enableDebug = true
+	enableDebug.Store(newConf.EnableDebug)
+	if addr := newConf.Server("router").Addr; addr != "" && addr != routerAddr() {
+		log.Info("router server address", addr)
+		defaultRouterAddr.Store(addr)
 	}
-	if addr := conf.Server("router").Addr; addr != "" {
-		defaultRouterAddr = addr
-	}
-	log.Info("router server address", defaultRouterAddr)
+}
+
+func routerAddr() string {
+	return defaultRouterAddr.Load().(string)
 }
 
 func Bind(name string, h Handler, args any, opt ...bindOptionFunc) {
@@ -42,16 +50,23 @@ func Hook(h Handler) {
 	defaultCmdSet.Hook(h)
 }
 
+func Use(mw ...Middleware) {
+	defaultCmdSet.Use(mw...)
+}
+
 // 绑定，函数名作为消息ID
 // 注：客户端发送的消息ID仅允许包含字母、数字
 func BindFunc(h Handler, args any, opt ...bindOptionFunc) {
+	defaultCmdSet.BindFunc(h, args, opt...)
+}
+
+func funcName(h Handler) string {
 	name := runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
 	n := strings.LastIndexByte(name, '.')
 	if n >= 0 {
 		name = name[n+1:]
 	}
-	// log.Debug("method name =", name)
-	Bind(name, h, args, opt...)
+	return name
 }
 
 func Handle(ctx *Context, name string, data []byte) error {
@@ -64,6 +79,8 @@ type ServiceConfig struct {
 	Addr      string `json:"addr,omitempty"`      // 地址
 	MinWeight int    `json:"minWeight,omitempty"` // 最小的负载
 	MaxWeight int    `json:"maxWeight,omitempty"` // 最大的负载
+
+	Labels map[string]string `json:"labels,omitempty"` // 服务的标签，如version、region、zone
 }
 
 type cmdArgs struct {
@@ -94,13 +111,22 @@ func (m M) MarshalJSON() ([]byte, error) {
 
 type forwardArgs struct {
 	ServerName string          `json:"serverName,omitempty"`
+	ServerId   string          `json:"serverId,omitempty"`
 	MsgId      string          `json:"msgId,omitempty"`
 	MsgData    json.RawMessage `json:"msgData,omitempty"`
+	Seq        uint64          `json:"seq,omitempty"`
+	Sender     string          `json:"sender,omitempty"`
+	ReplyTo    string          `json:"replyTo,omitempty"`
+	Acked      uint64          `json:"acked,omitempty"`
 }
 
 // 消息通过router转发
 // name = "*"：向所有非网关服务转发消息
 func Forward(name string, msgId string, i any) {
+	defaultCmdSet.Forward(name, msgId, i)
+}
+
+func (s *CmdSet) Forward(name string, msgId string, i any) {
 	buf, err := marshalJSON(i)
 	if err != nil {
 		return
@@ -111,12 +137,16 @@ func Forward(name string, msgId string, i any) {
 		MsgId:      msgId,
 		MsgData:    buf,
 	}
-	Route("router", "c2s_route", args)
+	s.Route("router", "c2s_route", args)
 }
 
 // 同步请求
 func Request(serverName, msgId string, in any) ([]byte, error) {
-	addr, err := RequestServerAddr(serverName)
+	return defaultCmdSet.Request(serverName, msgId, in)
+}
+
+func (s *CmdSet) Request(serverName, msgId string, in any) ([]byte, error) {
+	addr, err := s.RequestServerAddr(serverName)
 	if err != nil {
 		return nil, err
 	}
@@ -127,7 +157,7 @@ func Request(serverName, msgId string, in any) ([]byte, error) {
 	defer rwc.Close()
 
 	c := &TCPConn{rwc: rwc}
-	buf, err := authCodec.Encode(&Package{Id: msgId, Body: in, Ts: time.Now().Unix()})
+	buf, err := authCodec.Load().Encode(&Package{Id: msgId, Body: in, Ts: time.Now().Unix()})
 	if err != nil {
 		return nil, err
 	}
@@ -148,12 +178,16 @@ func Request(serverName, msgId string, in any) ([]byte, error) {
 
 // 向路由请求服务器地址
 func RequestServerAddr(name string) (string, error) {
+	return defaultCmdSet.RequestServerAddr(name)
+}
+
+func (s *CmdSet) RequestServerAddr(name string) (string, error) {
 	if name == "router" {
-		return defaultRouterAddr, nil
+		return s.RouterAddr(), nil
 	}
 
 	req := cmdArgs{Name: name}
-	buf, err := Request("router", "c2s_getServerAddr", req)
+	buf, err := s.Request("router", "c2s_getServerAddr", req)
 	if err != nil {
 		return "", err
 	}
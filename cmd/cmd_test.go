@@ -1,8 +1,18 @@
 package cmd_test
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/guogeer/quasar/v2/cmd"
 	"github.com/guogeer/quasar/v2/utils"
@@ -32,3 +42,556 @@ func TestM(t *testing.T) {
 		t.Errorf("check M.MarshalJSON m1:%s m2:%s", buf1, buf2)
 	}
 }
+
+type pingArgs struct {
+	N int `json:"n,omitempty"`
+}
+
+func TestMultiCmdSet(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverSet, clientSet := cmd.NewCmdSet(), cmd.NewCmdSet()
+	serverSet.Bind("ping", func(ctx *cmd.Context, data any) {
+		args := data.(*pingArgs)
+		ctx.Out.WriteJSON("pong", pingArgs{N: args.N + 1})
+	}, (*pingArgs)(nil))
+
+	pong := make(chan int, 1)
+	clientSet.Bind("pong", func(ctx *cmd.Context, data any) {
+		pong <- data.(*pingArgs).N
+	}, (*pingArgs)(nil))
+	// 默认CmdSet未绑定消息
+	if err := cmd.Handle(&cmd.Context{}, "ping", nil); err == nil {
+		t.Error("default cmd set handle unbound message")
+	}
+
+	srv := &cmd.Server{CmdSet: serverSet}
+	go srv.Serve(l)
+	clientSet.SetRouterAddr(l.Addr().String())
+	clientSet.Route("router", "ping", pingArgs{N: 1})
+
+	select {
+	case n := <-pong:
+		if n != 2 {
+			t.Errorf("pong %d", n)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("wait pong timeout")
+	}
+}
+
+type testConn struct {
+	msgs []*cmd.Package
+}
+
+func (c *testConn) Write(buf []byte) error {
+	pkg := &cmd.Package{}
+	if err := json.Unmarshal(buf, pkg); err != nil {
+		return err
+	}
+	c.msgs = append(c.msgs, pkg)
+	return nil
+}
+
+func (c *testConn) WriteJSON(msgId string, i any) error {
+	buf, err := cmd.EncodePackage(&cmd.Package{Id: msgId, Body: i})
+	if err != nil {
+		return err
+	}
+	return c.Write(buf)
+}
+
+func (c *testConn) RemoteAddr() string { return "127.0.0.1:1" }
+func (c *testConn) Close()             {}
+
+func TestBindTyped(t *testing.T) {
+	set := cmd.NewCmdSet()
+	cmd.BindTypedTo(set, "c2s_ping", func(ctx *cmd.Context, args *pingArgs) (*pingArgs, error) {
+		if args.N < 0 {
+			return nil, cmd.NewError("negative", "n is negative")
+		}
+		return &pingArgs{N: args.N + 1}, nil
+	})
+
+	out := &testConn{}
+	set.Handle(&cmd.Context{Out: out}, "c2s_ping", []byte(`{"n":1}`))
+	set.Handle(&cmd.Context{Out: out}, "c2s_ping", []byte(`{"n":-1}`))
+	// 网关转发的客户端消息
+	set.Handle(&cmd.Context{Out: out, Ssid: "ss1"}, "c2s_ping", []byte(`{"n":2}`))
+
+	expects := []string{
+		`s2c_ping {"data":{"n":2}}`,
+		`s2c_ping {"code":"negative","msg":"n is negative"}`,
+		`func_route {"id":"s2c_ping","data":{"data":{"n":3}}}`,
+	}
+	if len(out.msgs) != len(expects) {
+		t.Fatalf("reply %d messages", len(out.msgs))
+	}
+	for i, pkg := range out.msgs {
+		if s := pkg.Id + " " + string(pkg.Data); s != expects[i] {
+			t.Errorf("reply %s, expect %s", s, expects[i])
+		}
+	}
+	if out.msgs[2].Ssid != "ss1" {
+		t.Errorf("reply session %s", out.msgs[2].Ssid)
+	}
+}
+
+type validateArgs struct {
+	Name string `json:"name,omitempty" binding:"required"`
+	N    int    `json:"n,omitempty" binding:"min=1"`
+}
+
+func TestValidateArgs(t *testing.T) {
+	set := cmd.NewCmdSet()
+	var calls int
+	set.Bind("c2s_validate", func(ctx *cmd.Context, data any) { calls++ }, (*validateArgs)(nil))
+
+	out := &testConn{}
+	for _, data := range []string{`{"name":"a","n":1}`, `{"n":1}`, `{"name":"a"}`} {
+		set.Handle(&cmd.Context{Out: out}, "c2s_validate", []byte(data))
+	}
+	if calls != 1 || len(out.msgs) != 2 {
+		t.Fatalf("handle valid message %d, reply %d", calls, len(out.msgs))
+	}
+	reply := &cmd.Reply{}
+	json.Unmarshal(out.msgs[0].Data, reply)
+	if out.msgs[0].Id != "s2c_validate" || reply.Code != cmd.CodeInvalidArgs {
+		t.Errorf("reply invalid args %s %s", out.msgs[0].Id, out.msgs[0].Data)
+	}
+
+	// 服务间的内部消息不回复
+	set.Bind("func_validate", func(ctx *cmd.Context, data any) { calls++ }, (*validateArgs)(nil), cmd.WithPrivate())
+	set.Handle(&cmd.Context{Out: out}, "func_validate", []byte(`{"n":1}`))
+	if calls != 1 || len(out.msgs) != 2 {
+		t.Errorf("handle invalid private message %d, reply %d", calls, len(out.msgs))
+	}
+	if stats := set.InvalidStats(); stats["c2s_validate"] != 2 || stats["func_validate"] != 1 {
+		t.Errorf("invalid stats %v", stats)
+	}
+}
+
+func TestPrivateFromClient(t *testing.T) {
+	set := cmd.NewCmdSet()
+	var calls int
+	set.Bind("func_push", func(ctx *cmd.Context, data any) { calls++ }, nil, cmd.WithPrivate())
+
+	// 服务间的消息可处理，客户端的消息拒绝
+	if err := set.Handle(&cmd.Context{Out: &testConn{}}, "func_push", nil); err != nil || calls != 1 {
+		t.Errorf("handle private message %v %d", err, calls)
+	}
+	if err := set.Handle(&cmd.Context{Out: &testConn{}, Ssid: "ss1", IsClient: true}, "func_push", nil); err == nil || calls != 1 {
+		t.Errorf("client handle private message %v %d", err, calls)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	set := cmd.NewCmdSet()
+	set.Bind("c2s_validate", func(ctx *cmd.Context, data any) {}, (*validateArgs)(nil), cmd.WithPrivate())
+	cmd.BindTypedTo(set, "c2s_ping", func(ctx *cmd.Context, args *pingArgs) ([]pingArgs, error) { return nil, nil })
+
+	doc := set.Schema()
+	buf, _ := json.Marshal(doc)
+	expect := `{"$schema":"https://json-schema.org/draft/2020-12/schema",` +
+		`"routes":[{"id":"c2s_ping","replyId":"s2c_ping","args":{"$ref":"#/$defs/pingArgs"},"reply":{"type":"array","items":{"$ref":"#/$defs/pingArgs"}}},` +
+		`{"id":"c2s_validate","isPrivate":true,"args":{"$ref":"#/$defs/validateArgs"}}],` +
+		`"$defs":{"pingArgs":{"type":"object","properties":{"n":{"type":"integer"}},"x-order":["n"]},` +
+		`"validateArgs":{"type":"object","properties":{"n":{"type":"integer","minimum":1},"name":{"type":"string"}},"required":["name"],"x-order":["name","n"]}}}`
+	if string(buf) != expect {
+		t.Errorf("schema %s", buf)
+	}
+}
+
+func TestSchemaDefName(t *testing.T) {
+	set := cmd.NewCmdSet()
+	set.Bind("c2s_a", func(ctx *cmd.Context, data any) {}, (*pingArgs)(nil))
+	{
+		type pingArgs struct{ M int }
+		set.Bind("c2s_b", func(ctx *cmd.Context, data any) {}, (*pingArgs)(nil))
+	}
+	{
+		type pingArgs struct{ S string }
+		set.Bind("c2s_c", func(ctx *cmd.Context, data any) {}, (*pingArgs)(nil))
+	}
+
+	// 同名同包的类型不覆盖
+	doc := set.Schema()
+	var refs []string
+	for _, route := range doc.Routes {
+		refs = append(refs, route.Args.Ref)
+	}
+	expect := "#/$defs/pingArgs,#/$defs/cmd_testpingArgs,#/$defs/cmd_testpingArgs2"
+	if strings.Join(refs, ",") != expect || len(doc.Defs) != 3 {
+		t.Errorf("schema refs %v defs %d", refs, len(doc.Defs))
+	}
+}
+
+func TestRouteRule(t *testing.T) {
+	rule := &cmd.RouteRule{Name: "hall", Labels: map[string]string{"version": "2"}, Percent: 30, UserIds: []string{"1001"}}
+	if !rule.MatchLabels(map[string]string{"version": "2", "zone": "a"}) || rule.MatchLabels(map[string]string{"version": "1"}) {
+		t.Error("match labels")
+	}
+	if !rule.Select("", "1001") {
+		t.Error("select user")
+	}
+	var n int
+	for i := 0; i < 1000; i++ {
+		if rule.Select(strconv.Itoa(i), "") {
+			n++
+		}
+	}
+	if n < 250 || n > 350 {
+		t.Errorf("select %d%% sessions", n/10)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	set := cmd.NewCmdSet()
+	defer set.Close()
+	queue := cmd.NewMsgQueue(16)
+	set.SetMsgQueue(queue)
+	set.SetRouterAddr(l.Addr().String())
+	set.RegisterService(&cmd.ServiceConfig{Id: "hall_1", Name: "hall"})
+
+	// 主循环阻塞时不回复，路由超时后标记为不健康
+	c := &testConn{}
+	set.Handle(&cmd.Context{Out: c}, "s2c_healthCheck", nil)
+	if len(c.msgs) != 0 {
+		t.Fatalf("health check reply without main loop %v", c.msgs[0].Id)
+	}
+	queue.RunOnce()
+	if len(c.msgs) != 1 || c.msgs[0].Id != "c2s_reportHealth" {
+		t.Errorf("health check reply %d", len(c.msgs))
+	}
+}
+
+func TestBindQueue(t *testing.T) {
+	set := cmd.NewCmdSet()
+	queue := cmd.NewMsgQueue(16)
+	set.SetMsgQueue(queue)
+	var calls []string
+	set.Bind("c2s_direct", func(ctx *cmd.Context, data any) { calls = append(calls, "direct") }, nil)
+	set.Bind("c2s_queue", func(ctx *cmd.Context, data any) { calls = append(calls, "queue") }, nil, cmd.WithQueue())
+	set.Bind("c2s_without", func(ctx *cmd.Context, data any) { calls = append(calls, "without") }, nil, cmd.WithoutQueue())
+	for _, msgId := range []string{"c2s_queue", "c2s_without", "c2s_direct"} {
+		set.Handle(&cmd.Context{Out: &testConn{}}, msgId, nil)
+	}
+	// 默认直接处理，WithQueue及WithoutQueue入队列
+	if strings.Join(calls, ",") != "direct" {
+		t.Fatalf("handle before queue %v", calls)
+	}
+	queue.RunOnce()
+	queue.RunOnce()
+	if strings.Join(calls, ",") != "direct,queue,without" {
+		t.Errorf("handle in queue %v", calls)
+	}
+}
+
+func TestRouteReliable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverSet, clientSet := cmd.NewCmdSet(), cmd.NewCmdSet()
+	recv := make(chan int, 8)
+	serverSet.Bind("c2s_pay", func(ctx *cmd.Context, data any) {
+		recv <- data.(*pingArgs).N
+	}, (*pingArgs)(nil))
+
+	srv := &cmd.Server{CmdSet: serverSet}
+	go srv.Serve(l)
+	clientSet.SetRouterAddr(l.Addr().String())
+	// 内存中仅保存1个消息，其余写入磁盘
+	dir := t.TempDir()
+	if err := clientSet.SetOutboxSpill(dir, 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		if err := clientSet.RouteReliable("router", "c2s_pay", pingArgs{N: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	counts := map[int]int{}
+	for len(counts) < 3 {
+		select {
+		case n := <-recv:
+			counts[n]++
+		case <-time.After(10 * time.Second):
+			t.Fatalf("wait reliable messages timeout %v", counts)
+		}
+	}
+	for n, c := range counts {
+		if c != 1 {
+			t.Errorf("message %d handled %d times", n, c)
+		}
+	}
+}
+
+// 按帧转发消息的代理，drop返回true时丢弃发送方的消息
+func serveReliableProxy(t *testing.T, l net.Listener, upstream string, drop func(pkg *cmd.Package) bool) {
+	for {
+		rwc, err := l.Accept()
+		if err != nil {
+			return
+		}
+		urwc, err := net.Dial("tcp", upstream)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		down, up := cmd.NewTCPConn(rwc, ""), cmd.NewTCPConn(urwc, "")
+		go down.WriteLoop(ctx)
+		go up.WriteLoop(ctx)
+		go func() {
+			defer cancel()
+			for {
+				mt, buf, err := up.ReadMessage()
+				if err != nil {
+					return
+				}
+				if mt == cmd.RawMessage {
+					down.Write(buf)
+				}
+			}
+		}()
+		go func() {
+			defer cancel()
+			for {
+				mt, buf, err := down.ReadMessage()
+				if err != nil {
+					return
+				}
+				pkg := &cmd.Package{}
+				if mt != cmd.RawMessage || json.Unmarshal(buf, pkg) != nil || drop(pkg) {
+					continue
+				}
+				up.Write(buf)
+			}
+		}()
+	}
+}
+
+// 丢弃第一个消息，后续的消息先到达，接收方需等待重发后按序处理
+func TestRouteReliableReorder(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	pl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.Close()
+
+	serverSet, clientSet := cmd.NewCmdSet(), cmd.NewCmdSet()
+	recv := make(chan int, 8)
+	serverSet.Bind("c2s_pay", func(ctx *cmd.Context, data any) {
+		recv <- data.(*pingArgs).N
+	}, (*pingArgs)(nil))
+	srv := &cmd.Server{CmdSet: serverSet}
+	go srv.Serve(l)
+
+	var isDropped atomic.Bool
+	go serveReliableProxy(t, pl, l.Addr().String(), func(pkg *cmd.Package) bool {
+		return pkg.Id == "c2s_pay" && pkg.Seq == 1 && isDropped.CompareAndSwap(false, true)
+	})
+	clientSet.SetRouterAddr(pl.Addr().String())
+	for i := 1; i <= 3; i++ {
+		if err := clientSet.RouteReliable("router", "c2s_pay", pingArgs{N: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var seqs []int
+	for len(seqs) < 3 {
+		select {
+		case n := <-recv:
+			seqs = append(seqs, n)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("wait reliable messages timeout %v", seqs)
+		}
+	}
+	select {
+	case n := <-recv:
+		seqs = append(seqs, n)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if fmt.Sprint(seqs) != "[1 2 3]" {
+		t.Errorf("handle reliable messages %v", seqs)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "record.jsonl")
+	rec, err := cmd.NewRecorder(path, "hall_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := cmd.NewCmdSet()
+	set.SetRecorder(rec)
+	cmd.BindTypedTo(set, "c2s_ping", func(ctx *cmd.Context, args *pingArgs) (*pingArgs, error) {
+		return &pingArgs{N: args.N + 1}, nil
+	})
+	set.Handle(&cmd.Context{Out: &testConn{}, Ssid: "ss1", UserId: "1001"}, "c2s_ping", []byte(`{"n":1}`))
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := cmd.NewRecordReader(bytes.NewReader(buf)).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Out || r.Server != "hall_1" || r.Id != "c2s_ping" || r.Ssid != "ss1" || r.UserId != "1001" || string(r.Data) != `{"n":1}` {
+		t.Errorf("record %+v %+v", r, r.Package)
+	}
+
+	// 入队的消息在出队时录制，录制的顺序即处理的顺序
+	path = filepath.Join(t.TempDir(), "queue.jsonl")
+	if rec, err = cmd.NewRecorder(path, "hall_1"); err != nil {
+		t.Fatal(err)
+	}
+	queue := cmd.NewMsgQueue(16)
+	set.SetMsgQueue(queue)
+	set.SetRecorder(rec)
+	set.Bind("c2s_queue", func(ctx *cmd.Context, data any) {}, nil, cmd.WithQueue())
+	set.Handle(&cmd.Context{Out: &testConn{}}, "c2s_queue", nil)
+	set.Handle(&cmd.Context{Out: &testConn{}}, "c2s_ping", []byte(`{"n":1}`))
+	queue.RunOnce()
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf, err = os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for reader := cmd.NewRecordReader(bytes.NewReader(buf)); ; {
+		r, err := reader.Next()
+		if err != nil {
+			break
+		}
+		if !r.Out {
+			ids = append(ids, r.Id)
+		}
+	}
+	if strings.Join(ids, ",") != "c2s_ping,c2s_queue" {
+		t.Errorf("record order %v", ids)
+	}
+
+	// 回放时定时器按录制的时间在消息之间执行
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	records := []string{
+		`{"t":%d,"id":"c2s_start","ssid":"ss1"}`,
+		`{"t":%d,"out":true,"id":"s2c_start","ssid":"ss1"}`,
+		`{"t":%d,"id":"c2s_check","ssid":"ss1"}`,
+	}
+	var recording bytes.Buffer
+	for i, s := range records {
+		fmt.Fprintf(&recording, s+"\n", start.Add(time.Duration(i)*5*time.Second).UnixMilli())
+	}
+
+	var events []string
+	replaySet := cmd.NewCmdSet()
+	replaySet.Bind("c2s_start", func(ctx *cmd.Context, data any) {
+		events = append(events, "start "+utils.Now().Sub(start).String())
+		utils.NewTimer(func() {
+			events = append(events, "timer "+utils.Now().Sub(start).String())
+		}, 3*time.Second)
+	}, nil)
+	replaySet.Bind("c2s_check", func(ctx *cmd.Context, data any) {
+		events = append(events, "check "+utils.Now().Sub(start).String())
+	}, nil)
+	if err := replaySet.Replay(&recording, cmd.ReplayOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expects := []string{"start 0s", "timer 3s", "check 10s"}
+	if strings.Join(events, ",") != strings.Join(expects, ",") {
+		t.Errorf("replay events %v, expect %v", events, expects)
+	}
+	if d := time.Since(utils.Now()); d < 0 || d > time.Minute {
+		t.Errorf("clock not restored %v", d)
+	}
+}
+
+// 回放时可靠消息仅录制，不连接其他服务；客户端发送的内部消息不处理
+func TestReplayReliable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	set := cmd.NewCmdSet()
+	set.SetRouterAddr(l.Addr().String())
+	path := filepath.Join(t.TempDir(), "replay.jsonl")
+	rec, err := cmd.NewRecorder(path, "hall_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.SetRecorder(rec)
+	var calls []string
+	set.Bind("c2s_pay", func(ctx *cmd.Context, data any) {
+		calls = append(calls, "pay")
+		if err := ctx.CmdSet().RouteReliable("bank_1", "func_addGold", pingArgs{N: 1}); err != nil {
+			t.Error(err)
+		}
+		if err := ctx.CmdSet().ForwardReliable("bank", "func_addGold", pingArgs{N: 2}); err != nil {
+			t.Error(err)
+		}
+	}, nil)
+	set.Bind("func_addGold", func(ctx *cmd.Context, data any) {
+		calls = append(calls, "addGold")
+	}, nil, cmd.WithPrivate())
+
+	recording := `{"t":1,"id":"c2s_pay","ssid":"ss1"}
+{"t":2,"id":"func_addGold","ssid":"ss1","isClient":true}
+`
+	if err := set.Replay(strings.NewReader(recording), cmd.ReplayOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(calls, ",") != "pay" {
+		t.Errorf("replay calls %v", calls)
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var outs []string
+	for reader := cmd.NewRecordReader(bytes.NewReader(buf)); ; {
+		r, err := reader.Next()
+		if err != nil {
+			break
+		}
+		if r.Out {
+			outs = append(outs, r.To+" "+r.Id)
+			if r.Seq != 0 || r.Sender != "" {
+				t.Errorf("record reliable seq %d sender %s", r.Seq, r.Sender)
+			}
+		}
+	}
+	if strings.Join(outs, ",") != "bank_1 func_addGold,router c2s_route" {
+		t.Errorf("replay record outs %v", outs)
+	}
+
+	l.(*net.TCPListener).SetDeadline(time.Now().Add(200 * time.Millisecond))
+	if c, err := l.Accept(); err == nil {
+		c.Close()
+		t.Error("connect router during replay")
+	}
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
@@ -9,6 +10,7 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/guogeer/quasar/v2/log"
@@ -47,6 +49,45 @@ type TCPConn struct {
 	mu      sync.RWMutex
 }
 
+// 创建连接，需调用WriteLoop发送消息。网关等可复用相同的协议格式
+func NewTCPConn(rwc net.Conn, ssid string) *TCPConn {
+	return &TCPConn{
+		ssid: ssid,
+		rwc:  rwc,
+		send: make(chan []byte, 32<<10),
+		pong: make(chan bool, 1),
+	}
+}
+
+func (c *TCPConn) SetReadDeadline(t time.Time) error {
+	return c.rwc.SetReadDeadline(t)
+}
+
+// 发送队列中的消息并回复心跳，连接关闭或ctx结束后返回
+func (c *TCPConn) WriteLoop(ctx context.Context) {
+	for {
+		select {
+		case buf, ok := <-c.send:
+			if !ok {
+				return
+			}
+			// 忽略过大消息
+			if _, err := c.writeMsg(RawMessage, buf); err != nil {
+				log.Debugf("write %v", err)
+				if err != errTooLargeMessage {
+					return
+				}
+			}
+		case <-c.pong:
+			if _, err := c.writeMsg(PongMessage, []byte{}); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 func (c *TCPConn) Close() {
 	c.rwc.Close()
 
@@ -132,6 +173,9 @@ func (c *TCPConn) writeMsg(mt int, data []byte) (int, error) {
 
 type Handler func(*Context, any)
 
+// 中间件，包装绑定的消息处理函数。可用于日志、统计、转发事件等
+type Middleware func(next Handler) Handler
+
 type cmdEntry struct {
 	name       string
 	h          Handler
@@ -139,19 +183,31 @@ type cmdEntry struct {
 	inQueue    bool // 请求入消息队列处理
 	isPrivate  bool // 内部消息，不对外开放
 	serverName string
+	replyId    string       // 回复的消息ID
+	replyType  reflect.Type // 回复的数据类型，用于生成协议描述
 }
 
 type bindOption struct {
 	isPrivate  bool
-	inQueue    bool
+	inQueue    bool // 默认在连接的协程中直接处理
 	serverName string
+	replyId    string
+	replyType  reflect.Type
 }
 
 type bindOptionFunc func(opt *bindOption)
 
+// 消息入队列，在调用RunOnce的协程中依次处理
+func WithQueue() bindOptionFunc {
+	return func(opt *bindOption) {
+		opt.inQueue = true
+	}
+}
+
+// 兼容已有的用法，同WithQueue：消息入队列处理
 func WithoutQueue() bindOptionFunc {
 	return func(opt *bindOption) {
-		opt.inQueue = false
+		opt.inQueue = true
 	}
 }
 
@@ -167,15 +223,70 @@ func WithServer(name string) bindOptionFunc {
 	}
 }
 
+// 回复的消息ID。BindTyped默认c2s_xxx回复s2c_xxx
+func WithReply(msgId string) bindOptionFunc {
+	return func(opt *bindOption) {
+		opt.replyId = msgId
+	}
+}
+
+// 消息处理集合。包括消息绑定、消息队列、会话及向其他服务的连接
+// 同一进程可创建多个互相独立的CmdSet，如对外服务与后台管理服务
 type CmdSet struct {
 	table map[string]*cmdEntry
 	mu    sync.RWMutex
 
-	hook Handler // 调用顺序：hook->bind
+	hook        Handler      // 调用顺序：hook->middlewares->bind
+	middlewares []Middleware // 先添加的在外层
+
+	queue      *MsgQueue
+	routerAddr string   // 路由地址。为空时使用配置的地址
+	clients    sync.Map // 向其他服务的连接，已存在的连接不会被删除
+
+	sessions  map[string]*Session
+	sessionMu sync.RWMutex
+
+	healthFunc HealthFunc
+	healthOnce sync.Once
+
+	reliable reliableState
+	isClosed atomic.Bool
+
+	recorder    atomic.Pointer[Recorder] // 录制消息
+	isReplaying atomic.Bool              // 回放时不向其他服务发送消息
+}
+
+// 默认使用全局的消息队列
+func NewCmdSet() *CmdSet {
+	return &CmdSet{
+		table:    make(map[string]*cmdEntry),
+		queue:    defaultMsgQueue,
+		sessions: make(map[string]*Session),
+	}
+}
+
+var defaultCmdSet = NewCmdSet()
+
+// 设置消息队列，需在处理消息前设置
+func (s *CmdSet) SetMsgQueue(q *MsgQueue) {
+	s.queue = q
+}
+
+// 设置路由地址，需在发送消息前设置
+func (s *CmdSet) SetRouterAddr(addr string) {
+	s.routerAddr = addr
+}
+
+func (s *CmdSet) RouterAddr() string {
+	if s.routerAddr != "" {
+		return s.routerAddr
+	}
+	return routerAddr()
 }
 
-var defaultCmdSet = &CmdSet{
-	table: make(map[string]*cmdEntry),
+// 绑定，函数名作为消息ID
+func (s *CmdSet) BindFunc(h Handler, args any, opt ...bindOptionFunc) {
+	s.Bind(funcName(h), h, args, opt...)
 }
 
 func (s *CmdSet) Bind(name string, h Handler, i any, opt ...bindOptionFunc) {
@@ -186,12 +297,12 @@ func (s *CmdSet) Bind(name string, h Handler, i any, opt ...bindOptionFunc) {
 		type_ = reflect.TypeOf(i)
 	}
 
-	optResult := &bindOption{inQueue: true}
+	optResult := &bindOption{}
 	for _, fn := range opt {
 		fn(optResult)
 	}
 
-	e := &cmdEntry{name: name, h: h, type_: type_, inQueue: !optResult.inQueue, isPrivate: optResult.isPrivate, serverName: optResult.serverName}
+	e := &cmdEntry{name: name, h: h, type_: type_, inQueue: optResult.inQueue, isPrivate: optResult.isPrivate, serverName: optResult.serverName, replyId: optResult.replyId, replyType: optResult.replyType}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -215,10 +326,34 @@ func (s *CmdSet) Hook(h Handler) {
 	s.hook = h
 }
 
+// 添加中间件，在处理消息的协程中执行
+func (s *CmdSet) Use(mw ...Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.middlewares = append(s.middlewares, mw...)
+}
+
 func (s *CmdSet) Handle(ctx *Context, msgId string, data []byte) error {
 	msgId = strings.ToLower(msgId)
 
 	ctx.MsgId = msgId
+	ctx.set = s
+	// 可靠消息处理后回复，已处理的消息不再处理
+	var isQueued bool
+	if ctx.seq > 0 {
+		switch s.checkReliable(ctx.sender, ctx.seq, ctx.acked) {
+		case reliableDone:
+			ctx.ackReliable()
+			return nil
+		case reliableIgnore:
+			return nil
+		}
+		defer func() {
+			if !isQueued {
+				ctx.ackReliable()
+			}
+		}()
+	}
 	// 空数据使用默认JSON格式数据
 	if len(data) == 0 {
 		data = []byte("{}")
@@ -231,10 +366,15 @@ func (s *CmdSet) Handle(ctx *Context, msgId string, data []byte) error {
 		e = s.table[strings.Join([]string{ctx.ServerName, name}, ".")]
 	}
 	hook := s.hook
+	middlewares := s.middlewares
 	s.mu.RUnlock()
+	// 入队的消息在出队时录制
+	if e == nil || !e.inQueue {
+		s.recordIn(ctx, msgId, data)
+	}
 	// 转发消息
 	if len(serverName) > 0 {
-		if ss := GetSession(ctx.Ssid); ss != nil {
+		if ss := s.GetSession(ctx.Ssid); ss != nil {
 			ss.routeContext(ctx, name, data)
 		}
 		return nil
@@ -243,7 +383,7 @@ func (s *CmdSet) Handle(ctx *Context, msgId string, data []byte) error {
 	if e == nil {
 		return errors.New("invalid message id")
 	}
-	if e.isPrivate && ctx.ServerName != "" {
+	if e.isPrivate && (ctx.ServerName != "" || ctx.IsClient) {
 		return errors.New("not allow message id")
 	}
 
@@ -253,19 +393,37 @@ func (s *CmdSet) Handle(ctx *Context, msgId string, data []byte) error {
 		if err := json.Unmarshal(data, args); err != nil {
 			return err
 		}
+		// 无效的参数不进入业务逻辑
+		if err := validateArgs(args); err != nil {
+			s.queue.addInvalid(name)
+			// 仅回复客户端的消息或指定了回复的消息，服务间的内部消息不回复
+			if !e.isPrivate || e.replyId != "" {
+				replyId := e.replyId
+				if replyId == "" {
+					replyId = replyMsgId(name)
+				}
+				ctx.Reply(replyId, newReply(nil, NewError(CodeInvalidArgs, err.Error())))
+			}
+			return err
+		}
 	}
 
+	h := e.h
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
 	// 消息入队处理
 	if e.inQueue {
-		msg := &msgTask{id: name, ctx: ctx, h: e.h, args: args, hook: hook}
-		defaultMsgQueue.q <- msg
+		msg := &msgTask{id: name, ctx: ctx, h: h, args: args, hook: hook, data: data}
+		isQueued = true
+		s.queue.q <- msg
 	} else {
 		// 消息直接处理。入网关转发数据时
 		if hook != nil {
 			hook(ctx, args)
 		}
 		if !ctx.isFail {
-			e.h(ctx, args)
+			h(ctx, args)
 		}
 	}
 
@@ -0,0 +1,53 @@
+package cmd
+
+// 服务的健康状态及负载
+// 路由定时发送s2c_healthCheck，服务在消息队列中回复c2s_reportHealth
+// 主循环阻塞时无法回复，路由超时后标记服务不健康
+
+// 服务的健康状态
+type ServiceHealth struct {
+	Unhealthy bool `json:"unhealthy,omitempty"` // 服务不可用，如停服维护
+	Weight    int  `json:"weight,omitempty"`    // 当前负载
+}
+
+// 查询服务的健康状态。健康检查时在消息队列中调用，ReportHealth时在调用方的协程中
+type HealthFunc func() ServiceHealth
+
+// 设置服务的健康状态及负载。未设置时为健康，负载为会话数
+func SetHealthFunc(f HealthFunc) {
+	defaultCmdSet.SetHealthFunc(f)
+}
+
+func (s *CmdSet) SetHealthFunc(f HealthFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.healthFunc = f
+}
+
+func (s *CmdSet) health() ServiceHealth {
+	s.mu.RLock()
+	f := s.healthFunc
+	s.mu.RUnlock()
+	if f == nil {
+		return ServiceHealth{Weight: s.CountSession()}
+	}
+	return f()
+}
+
+// 立即上报健康状态，如服务开始维护
+func ReportHealth() {
+	defaultCmdSet.ReportHealth()
+}
+
+func (s *CmdSet) ReportHealth() {
+	s.Route("router", "c2s_reportHealth", s.health())
+}
+
+// 注册服务时绑定，回复路由的健康检查。入消息队列处理，主循环阻塞时不回复
+func (s *CmdSet) bindHealthCheck() {
+	s.healthOnce.Do(func() {
+		s.Bind("s2c_healthCheck", func(ctx *Context, data any) {
+			ctx.Out.WriteJSON("c2s_reportHealth", s.health())
+		}, nil, WithPrivate(), WithQueue())
+	})
+}
@@ -6,8 +6,11 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"maps"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/guogeer/quasar/v2/log"
@@ -26,8 +29,24 @@ type Context struct {
 	Version     int    // 协议版本，当前未生效
 	ServerName  string // 请求的协议头
 	ClientAddr  string // 客户端地址
+	UserId      string // 网关认证后的用户ID
 	MatchServer string // 多个服务合并后的唯一serverName
+	IsClient    bool   // 客户端直接发送到网关的消息，不可处理内部消息
 	isFail      bool   // 失败处理后，不需要继续处理
+	set         *CmdSet
+
+	seq     uint64 // 可靠消息
+	acked   uint64
+	sender  string
+	replyTo string
+}
+
+// 处理消息的CmdSet
+func (ctx *Context) CmdSet() *CmdSet {
+	if ctx.set == nil {
+		return defaultCmdSet
+	}
+	return ctx.set
 }
 
 // 失败后不再处理后续消息
@@ -41,17 +60,74 @@ type msgTask struct {
 	hook Handler
 	ctx  *Context
 	args any
+	data []byte // 原始数据，出队时录制
 }
 
-type msgQueue struct {
+// 消息队列。同一队列的消息在调用RunOnce的协程中依次处理
+type MsgQueue struct {
 	q chan *msgTask
+
+	lastPrintTime time.Time // 10分钟打印一次
+	messageStats  map[string]messageStat
+
+	invalidStats     map[string]int // 参数校验失败的累计次数，在连接的协程中统计
+	invalidMu        sync.Mutex
+	invalidPrintTime time.Time // 10分钟打印一次，不依赖调试模式
+	invalidPrinted   int       // 上次打印时的总次数
+}
+
+func (mq *MsgQueue) addInvalid(id string) {
+	mq.invalidMu.Lock()
+	defer mq.invalidMu.Unlock()
+	if mq.invalidStats == nil {
+		mq.invalidStats = map[string]int{}
+	}
+	mq.invalidStats[id]++
+}
+
+// 参数校验失败的消息累计次数
+func (mq *MsgQueue) InvalidStats() map[string]int {
+	mq.invalidMu.Lock()
+	defer mq.invalidMu.Unlock()
+	return maps.Clone(mq.invalidStats)
+}
+
+// 默认消息队列中参数校验失败的消息累计次数
+func InvalidStats() map[string]int {
+	return defaultMsgQueue.InvalidStats()
+}
+
+// 消息队列中参数校验失败的消息累计次数
+func (s *CmdSet) InvalidStats() map[string]int {
+	return s.queue.InvalidStats()
+}
+
+// 参数校验失败的消息有新增时打印
+func (mq *MsgQueue) printInvalidStats() {
+	if time.Since(mq.invalidPrintTime) < 10*time.Minute {
+		return
+	}
+	mq.invalidPrintTime = time.Now()
+
+	stats := mq.InvalidStats()
+	var total int
+	for _, n := range stats {
+		total += n
+	}
+	if total == mq.invalidPrinted {
+		return
+	}
+	mq.invalidPrinted = total
+	for id, n := range stats {
+		log.Warnf("invalid args: %s %d", id, n)
+	}
 }
 
-func newMsgQueue(size int) *msgQueue {
-	return &msgQueue{q: make(chan *msgTask, size)}
+func NewMsgQueue(size int) *MsgQueue {
+	return &MsgQueue{q: make(chan *msgTask, size)}
 }
 
-var defaultMsgQueue = newMsgQueue(8 << 10)
+var defaultMsgQueue = NewMsgQueue(8 << 10)
 
 // 统计消息平均负载&访问频率等
 type messageStat struct {
@@ -60,75 +136,87 @@ type messageStat struct {
 	call int           // 调用次数
 }
 
-var (
-	lastPrintTime time.Time // 10分钟打印一次
-	messageStats  map[string]messageStat
-)
-
 func RunOnce() {
+	defaultMsgQueue.RunOnce()
+}
+
+// 处理队列中的一条消息，无消息时最多等待40ms
+func (mq *MsgQueue) RunOnce() {
 	var msg *msgTask
 	select {
-	case msg = <-defaultMsgQueue.q:
+	case msg = <-mq.q:
 	case <-time.After(40 * time.Millisecond):
 	}
+	mq.printInvalidStats()
 	if msg == nil {
 		return
 	}
 
 	var t time.Time
 	var stat messageStat
-	if enableDebug {
+	isDebug := enableDebug.Load()
+	if isDebug {
 		t = time.Now()
 	}
+	msg.ctx.CmdSet().recordIn(msg.ctx, msg.ctx.MsgId, msg.data)
 	if msg.hook != nil {
 		msg.hook(msg.ctx, msg.args)
 	}
 	if !msg.ctx.isFail {
 		msg.h(msg.ctx, msg.args)
 	}
+	msg.ctx.ackReliable()
 
-	if enableDebug {
+	if isDebug {
 		stat = messageStat{d: time.Since(t), call: 1}
 
-		if lastPrintTime.IsZero() {
-			lastPrintTime = time.Now()
+		if mq.lastPrintTime.IsZero() {
+			mq.lastPrintTime = time.Now()
 		}
-		if len(messageStats) == 0 {
-			messageStats = map[string]messageStat{}
+		if len(mq.messageStats) == 0 {
+			mq.messageStats = map[string]messageStat{}
 		}
 
-		oldStat := messageStats[msg.id]
+		oldStat := mq.messageStats[msg.id]
+		oldStat.id = msg.id
 		oldStat.d += stat.d
 		oldStat.call += stat.call
-		messageStats[msg.id] = oldStat
+		mq.messageStats[msg.id] = oldStat
 
 		var tpc []messageStat // cost time per call
 		var cps []messageStat // call per second
-		for _, stat := range messageStats {
+		for _, stat := range mq.messageStats {
 			tpc = append(tpc, stat)
 			cps = append(cps, stat)
 		}
 
-		d := time.Since(lastPrintTime)
+		d := time.Since(mq.lastPrintTime)
 		if d >= 10*time.Minute {
 			log.Debug("=========== message stats start  ============")
 			sort.SliceStable(tpc, func(i, j int) bool {
 				return tpc[i].d.Seconds()/float64(tpc[i].call) > tpc[j].d.Seconds()/float64(tpc[j].call)
 			})
 			sort.SliceStable(cps, func(i, j int) bool { return cps[i].call > cps[j].call })
-			for i := 0; i < 10 && i < len(messageStats); i++ {
+			for i := 0; i < 10 && i < len(mq.messageStats); i++ {
 				stat1, stat2 := tpc[i], cps[i]
 				log.Debugf("cost time per call: %s %.2fms, call per second %s %.2f", stat1.id, stat1.d.Seconds()*1000/float64(stat1.call), stat2.id, float64(stat2.call)/d.Seconds())
 			}
 			log.Debug("=========== message stats end  ============")
 
 			// 清理旧数据
-			messageStats = nil
-			lastPrintTime = time.Time{}
+			mq.messageStats = nil
+			mq.lastPrintTime = time.Time{}
 		}
 	}
 }
 
+// 处理队列中已有的消息，不等待
+func (mq *MsgQueue) runPending() {
+	for len(mq.q) > 0 {
+		mq.RunOnce()
+	}
+}
+
 type Package struct {
 	Id         string          `json:"id,omitempty"`         // 消息ID
 	Data       json.RawMessage `json:"data,omitempty"`       // 数据,object类型
@@ -138,6 +226,11 @@ type Package struct {
 	Ts         int64           `json:"ts,omitempty"`         // 过期时间戳
 	ServerName string          `json:"serverName,omitempty"` // 请求的协议头
 	ClientAddr string          `json:"clientAddr,omitempty"` // 客户端地址
+	UserId     string          `json:"userId,omitempty"`     // 网关认证后的用户ID
+	Seq        uint64          `json:"seq,omitempty"`        // 可靠消息的序号
+	Sender     string          `json:"sender,omitempty"`     // 可靠消息的发送方
+	Acked      uint64          `json:"acked,omitempty"`      // 可靠消息发送方已确认的序号
+	ReplyTo    string          `json:"replyTo,omitempty"`    // 经路由转发的可靠消息回复的服务ID
 
 	Body any `json:"-"` // 解析成Data
 }
@@ -145,18 +238,37 @@ type Package struct {
 // 服务内部协议
 var rawCodec = &hashCodec{}
 
+const (
+	defaultAuthKey   = "420e57b017066b44e05ea1577f6e2e12"
+	defaultClientKey = "helloworld!"
+)
+
 // 服务器内建立连接时将检验第一个包的数据
-var authCodec = &hashCodec{
-	secs:     5,
-	key:      "420e57b017066b44e05ea1577f6e2e12",
-	tempSign: "a9542bb104fe3f4d562e1d275e03f5ba",
-}
+var authCodec atomic.Pointer[hashCodec]
 
 // 外网客户端协议
-var clientCodec = &hashCodec{
-	ref:      []int{0, 3, 4, 8, 10, 11, 13, 14},
-	key:      "helloworld!",
-	tempSign: "12345678",
+var clientCodec atomic.Pointer[hashCodec]
+
+func init() {
+	setCodecKey("")
+}
+
+// 按校验KEY重建协议，KEY为空时使用默认值。已建立的连接使用新的KEY
+func setCodecKey(key string) {
+	authKey, clientKey := key, key
+	if key == "" {
+		authKey, clientKey = defaultAuthKey, defaultClientKey
+	}
+	authCodec.Store(&hashCodec{
+		secs:     5,
+		key:      authKey,
+		tempSign: "a9542bb104fe3f4d562e1d275e03f5ba",
+	})
+	clientCodec.Store(&hashCodec{
+		ref:      []int{0, 3, 4, 8, 10, 11, 13, 14},
+		key:      clientKey,
+		tempSign: "12345678",
+	})
 }
 
 // 协议使用哈希值检验
@@ -259,11 +371,11 @@ func (codec *hashCodec) Signature(data []byte) (string, error) {
 func Encode(name string, i any) ([]byte, error) {
 	buf, _ := marshalJSON(i)
 	pkg := &Package{Id: name, Data: buf}
-	return clientCodec.Encode(pkg)
+	return clientCodec.Load().Encode(pkg)
 }
 
 func Decode(buf []byte) (*Package, error) {
-	return clientCodec.Decode(buf)
+	return clientCodec.Load().Decode(buf)
 }
 
 func EncodePackage(pkg *Package) ([]byte, error) {
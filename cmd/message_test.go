@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/config"
 )
 
 func TestEncode(t *testing.T) {
@@ -46,3 +47,42 @@ func TestEncode(t *testing.T) {
 		t.Fatalf("cmd.Encode Signature Fail %s != %s", pkg.Sign, shortSign)
 	}
 }
+
+func TestBinary(t *testing.T) {
+	buf, err := cmd.EncodeBinary("test", map[string]any{"s": "hello"})
+	if err != nil {
+		t.Fatalf("cmd.EncodeBinary fail %v", err)
+	}
+	pkg, err := cmd.DecodeBinary(buf)
+	if err != nil || pkg.Id != "test" || string(pkg.Data) != `{"s":"hello"}` {
+		t.Fatalf("cmd.DecodeBinary fail %v %v", pkg, err)
+	}
+
+	// 修改数据后签名无效
+	buf[len(buf)-2] = 'x'
+	if _, err := cmd.DecodeBinary(buf); err != cmd.ErrInvalidSign {
+		t.Errorf("cmd.DecodeBinary invalid sign %v", err)
+	}
+	if _, err := cmd.DecodeBinary(append(buf, 0)); err == nil {
+		t.Error("cmd.DecodeBinary trailing data")
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	oldConf := config.Config()
+	defer config.SetConfig(oldConf)
+
+	config.SetConfig(&config.Env{ClientKey: "key1"})
+	buf, err := cmd.Encode("test", map[string]any{"n": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cmd.Decode(buf); err != nil {
+		t.Errorf("decode with same key %v", err)
+	}
+	// 修改KEY后重新生效
+	config.SetConfig(&config.Env{ClientKey: "key2"})
+	if _, err := cmd.Decode(buf); err != cmd.ErrInvalidSign {
+		t.Errorf("decode with changed key %v", err)
+	}
+}
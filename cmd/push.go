@@ -0,0 +1,29 @@
+package cmd
+
+// 按用户ID推送消息。路由根据网关上报的用户目录转发到用户所在的网关
+
+type pushArgs struct {
+	UserIds []string `json:"userIds,omitempty"`
+	MsgId   string   `json:"msgId,omitempty"`
+	MsgData any      `json:"msgData,omitempty"`
+}
+
+// 推送消息到用户的所有连接，用户不在线时忽略
+func (s *CmdSet) PushToUser(userId, msgId string, msgData any) {
+	s.PushToUsers([]string{userId}, msgId, msgData)
+}
+
+func (s *CmdSet) PushToUsers(userIds []string, msgId string, msgData any) {
+	if len(userIds) == 0 {
+		return
+	}
+	s.Route("router", "c2s_pushToUsers", &pushArgs{UserIds: userIds, MsgId: msgId, MsgData: msgData})
+}
+
+func PushToUser(userId, msgId string, msgData any) {
+	defaultCmdSet.PushToUser(userId, msgId, msgData)
+}
+
+func PushToUsers(userIds []string, msgId string, msgData any) {
+	defaultCmdSet.PushToUsers(userIds, msgId, msgData)
+}
@@ -0,0 +1,296 @@
+package cmd
+
+// 消息录制及回放，用于复现线上问题
+// 1、录制CmdSet收到及发出的消息，每行一条JSON追加写入文件
+// 2、回放时按录制的顺序将收到的消息交给新的CmdSet处理，发出的消息不再发送，可靠消息不保存也不重发
+// 3、回放时utils的时钟为录制的时间，到期的定时器在消息之前按序执行，与回放速度无关
+// 4、收到的消息在处理时录制，入队的消息在出队时录制，录制的顺序即处理的顺序
+// 注：回放需在主循环的协程中执行，业务逻辑需使用utils.Now获取时间
+// utils的时钟为进程内共享，回放期间进程内所有的CmdSet均使用录制的时间，同时仅允许一个回放
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/guogeer/quasar/v2/log"
+	"github.com/guogeer/quasar/v2/utils"
+)
+
+const recordFlushPeriod = time.Second
+
+// 回放替换了进程内共享的时钟，同时仅允许一个回放
+var isClockReplaying atomic.Bool
+
+// 录制的消息
+type Record struct {
+	Time     int64  `json:"t"`                  // 毫秒时间戳
+	Server   string `json:"server,omitempty"`   // 录制的服务ID
+	Out      bool   `json:"out,omitempty"`      // 发出的消息
+	To       string `json:"to,omitempty"`       // 发出消息的目标服务，为空时回复发送方
+	IsClient bool   `json:"isClient,omitempty"` // 客户端直接发送到网关的消息
+	*Package
+}
+
+type Recorder struct {
+	serverId string
+
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	isClose bool
+	stop    chan struct{}
+}
+
+// 录制消息到文件，已存在时追加。serverId为录制的服务ID
+func NewRecorder(path, serverId string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{serverId: serverId, f: f, w: bufio.NewWriter(f), stop: make(chan struct{})}
+	go r.flushLoop()
+	return r, nil
+}
+
+// 定时写入文件
+func (r *Recorder) flushLoop() {
+	ticker := time.NewTicker(recordFlushPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.Flush(); err != nil {
+				log.Errorf("flush record %v", err)
+			}
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (r *Recorder) write(rec *Record) {
+	rec.Time = utils.Now().UnixMilli()
+	rec.Server = r.serverId
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.isClose {
+		return
+	}
+	r.w.Write(buf)
+	r.w.WriteByte('\n')
+}
+
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.isClose {
+		return nil
+	}
+	return r.w.Flush()
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.isClose {
+		return nil
+	}
+	r.isClose = true
+	close(r.stop)
+	err := r.w.Flush()
+	if closeErr := r.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// 开启录制，为空时停止录制。需自行关闭Recorder
+func SetRecorder(r *Recorder) {
+	defaultCmdSet.SetRecorder(r)
+}
+
+func (s *CmdSet) SetRecorder(r *Recorder) {
+	s.recorder.Store(r)
+}
+
+// 录制收到的消息
+func (s *CmdSet) recordIn(ctx *Context, msgId string, data []byte) {
+	r := s.recorder.Load()
+	if r == nil {
+		return
+	}
+	pkg := &Package{
+		Id:         msgId,
+		Data:       data,
+		Ssid:       ctx.Ssid,
+		ServerName: ctx.ServerName,
+		ClientAddr: ctx.ClientAddr,
+		UserId:     ctx.UserId,
+	}
+	r.write(&Record{IsClient: ctx.IsClient, Package: pkg})
+}
+
+// 录制发出的消息，buf为编码后的Package
+func (s *CmdSet) recordOut(to string, buf []byte) {
+	r := s.recorder.Load()
+	if r == nil {
+		return
+	}
+	pkg := &Package{}
+	if err := json.Unmarshal(buf, pkg); err != nil {
+		return
+	}
+	pkg.Sign = ""
+	r.write(&Record{Out: true, To: to, Package: pkg})
+}
+
+// 按行读取录制的消息
+type RecordReader struct {
+	scanner *bufio.Scanner
+}
+
+func NewRecordReader(r io.Reader) *RecordReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 2*maxMessageSize)
+	return &RecordReader{scanner: scanner}
+}
+
+// 读取下一条消息，读完时返回io.EOF
+func (r *RecordReader) Next() (*Record, error) {
+	for r.scanner.Scan() {
+		line := r.scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		rec := &Record{}
+		if err := json.Unmarshal(line, rec); err != nil {
+			return nil, err
+		}
+		if rec.Package == nil {
+			rec.Package = &Package{}
+		}
+		return rec, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+type ReplayOptions struct {
+	Speed  float64            // 回放速度，1为原始速度，2为两倍速。为0时不等待
+	Filter func(*Record) bool // 需回放的消息，为空时回放所有收到的消息
+}
+
+// 回放时的连接，发出的消息仅录制
+type replayConn struct {
+	set  *CmdSet
+	addr string
+}
+
+func (c *replayConn) Write(buf []byte) error {
+	c.set.recordOut("", buf)
+	return nil
+}
+
+func (c *replayConn) WriteJSON(msgId string, i any) error {
+	buf, err := EncodePackage(&Package{Id: msgId, Body: i})
+	if err != nil {
+		return err
+	}
+	return c.Write(buf)
+}
+
+func (c *replayConn) RemoteAddr() string { return c.addr }
+func (c *replayConn) Close()             {}
+
+func Replay(r io.Reader, opts ReplayOptions) error {
+	return defaultCmdSet.Replay(r, opts)
+}
+
+// 回放录制的消息。处理时的时钟为录制的时间，结束后恢复
+// 发往其他服务的消息不再发送，开启录制时可对比两次录制的结果
+func (s *CmdSet) Replay(r io.Reader, opts ReplayOptions) error {
+	if !isClockReplaying.CompareAndSwap(false, true) {
+		return errors.New("replay is running")
+	}
+	defer isClockReplaying.Store(false)
+	s.isReplaying.Store(true)
+	defer s.isReplaying.Store(false)
+
+	// 连接的协程及录制时读取时钟
+	var now atomic.Int64
+	utils.SetClock(func() time.Time { return time.Unix(0, now.Load()) })
+	defer utils.SetClock(nil)
+
+	timers := utils.GetTimerSet()
+	// 按序执行到期的定时器
+	advance := func(t time.Time) {
+		for {
+			expire, ok := timers.NextExpire()
+			if !ok || expire.After(t) {
+				break
+			}
+			if expire.UnixNano() > now.Load() {
+				now.Store(expire.UnixNano())
+			}
+			timers.RunOnce()
+		}
+		now.Store(t.UnixNano())
+	}
+
+	reader := NewRecordReader(r)
+	conns := map[string]*replayConn{} // 同一会话使用相同的连接
+	var lastTime time.Time
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if rec.Out || (opts.Filter != nil && !opts.Filter(rec)) {
+			continue
+		}
+
+		t := time.UnixMilli(rec.Time)
+		if !lastTime.IsZero() && opts.Speed > 0 && t.After(lastTime) {
+			time.Sleep(time.Duration(float64(t.Sub(lastTime)) / opts.Speed))
+		}
+		if lastTime.IsZero() {
+			now.Store(t.UnixNano())
+		}
+		lastTime = t
+		advance(t)
+
+		conn, ok := conns[rec.Ssid]
+		if !ok {
+			conn = &replayConn{set: s, addr: "127.0.0.1:0"}
+			conns[rec.Ssid] = conn
+		}
+		ctx := &Context{
+			Out:        conn,
+			Ssid:       rec.Ssid,
+			ServerName: rec.ServerName,
+			ClientAddr: rec.ClientAddr,
+			UserId:     rec.UserId,
+			IsClient:   rec.IsClient,
+		}
+		if err := s.Handle(ctx, rec.Id, rec.Data); err != nil {
+			log.Debugf("replay msg[%s] error: %v", rec.Id, err)
+		}
+		s.queue.runPending()
+	}
+}
@@ -0,0 +1,475 @@
+package cmd
+
+// 可靠消息，至少送达一次。适用于货币、道具等不可丢失的消息
+// 1、发送方为每个目标保存待确认的消息，每个目标使用独立的发送方ID及连续的序号
+// 2、接收方处理消息后回复func_reliableAck，经路由转发的消息通过路由回复
+// 3、发送方重连后及超时未确认时重发，消息带已确认的序号，小于等于该序号的消息均已处理
+// 4、接收方按序号顺序处理，已处理的消息再次确认，处理中及不连续的消息忽略，等待发送方重发
+// 5、开启磁盘缓存时，待确认的消息超过内存上限后写入磁盘，进程重启后加载并重发
+// 注：接收方重启后去重记录丢失，业务需保证消息幂等
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/guogeer/quasar/v2/log"
+	"github.com/guogeer/quasar/v2/utils"
+)
+
+const (
+	reliableAckTimeout    = 5 * time.Second
+	reliableResendPeriod  = time.Second
+	defaultOutboxMemLimit = 16 << 10
+	dedupeIdleTime        = 10 * time.Minute
+
+	outboxFilePrefix = "outbox_"
+	outboxFileExt    = ".jsonl"
+)
+
+var (
+	errOutboxFull       = errors.New("reliable outbox is full")
+	errNotRegistered    = errors.New("forward reliable message need register service")
+	reliableAckMsgId    = "func_reliableAck"
+	reliableServerIdRep = strings.NewReplacer("/", "_", "\\", "_", "..", "_")
+)
+
+type reliableAckArgs struct {
+	Sender string `json:"sender,omitempty"`
+	Seq    uint64 `json:"seq,omitempty"`
+}
+
+type outboxEntry struct {
+	Sender     string          `json:"sender"`
+	Seq        uint64          `json:"seq"`
+	MsgId      string          `json:"msgId"`
+	Data       json.RawMessage `json:"data,omitempty"`
+	ServerName string          `json:"serverName,omitempty"` // 经路由转发的服务名
+	ReplyTo    string          `json:"replyTo,omitempty"`
+
+	sendTime time.Time
+}
+
+// 编码消息，acked为发送方已确认的序号
+func (entry *outboxEntry) encode(acked uint64) ([]byte, error) {
+	if entry.ServerName == "" {
+		return EncodePackage(&Package{Id: entry.MsgId, Data: entry.Data, Sender: entry.Sender, Seq: entry.Seq, Acked: acked})
+	}
+	args := &forwardArgs{
+		ServerName: entry.ServerName,
+		MsgId:      entry.MsgId,
+		MsgData:    entry.Data,
+		Seq:        entry.Seq,
+		Sender:     entry.Sender,
+		ReplyTo:    entry.ReplyTo,
+		Acked:      acked,
+	}
+	return EncodePackage(&Package{Id: "c2s_route", Body: args})
+}
+
+// 目标服务待确认的消息，按序号排列
+type outbox struct {
+	mu        sync.Mutex
+	entries   []*outboxEntry
+	seqs      map[string]uint64 // [sender:seq]
+	memLimit  int
+	spillPath string // 磁盘缓存的文件。为空时不写磁盘
+	spilled   int    // 磁盘中的消息数
+}
+
+func newOutbox(memLimit int, spillPath string) *outbox {
+	o := &outbox{memLimit: memLimit, spillPath: spillPath, seqs: map[string]uint64{}}
+	if o.memLimit <= 0 {
+		o.memLimit = defaultOutboxMemLimit
+	}
+	// 加载上次运行未确认的消息
+	if spillPath != "" {
+		if entries, err := readSpillFile(spillPath); err == nil {
+			o.spilled = len(entries)
+			o.refill()
+		}
+	}
+	return o
+}
+
+func readSpillFile(path string) ([]*outboxEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []*outboxEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 2*maxMessageSize)
+	for scanner.Scan() {
+		entry := &outboxEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			log.Warnf("invalid outbox entry %s", scanner.Bytes())
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func writeSpillFile(path string, entries []*outboxEntry) error {
+	if len(entries) == 0 {
+		err := os.Remove(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		b, _ := json.Marshal(entry)
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// 分配序号并保存待确认的消息，返回需要发送的数据。内存已满时写入磁盘，返回nil
+func (o *outbox) add(entry *outboxEntry) ([]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.spilled == 0 && len(o.entries) < o.memLimit {
+		entry.Seq = o.seqs[entry.Sender] + 1
+		buf, err := entry.encode(o.acked(entry.Sender, entry.Seq))
+		if err != nil {
+			return nil, err
+		}
+		o.seqs[entry.Sender] = entry.Seq
+		entry.sendTime = time.Now()
+		o.entries = append(o.entries, entry)
+		return buf, nil
+	}
+	if o.spillPath == "" {
+		return nil, errOutboxFull
+	}
+
+	entry.Seq = o.seqs[entry.Sender] + 1
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(o.spillPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return nil, err
+	}
+	o.seqs[entry.Sender] = entry.Seq
+	o.spilled++
+	return nil, nil
+}
+
+// 发送方已确认的序号，即待确认的最小序号减一
+func (o *outbox) acked(sender string, seq uint64) uint64 {
+	for _, entry := range o.entries {
+		if entry.Sender == sender {
+			return min(seq, entry.Seq) - 1
+		}
+	}
+	return seq - 1
+}
+
+// 从磁盘加载消息到内存
+func (o *outbox) refill() {
+	if o.spilled == 0 || len(o.entries) > o.memLimit/2 {
+		return
+	}
+	entries, err := readSpillFile(o.spillPath)
+	if err != nil {
+		log.Errorf("read outbox %s %v", o.spillPath, err)
+		return
+	}
+	n := min(o.memLimit-len(o.entries), len(entries))
+	if err := writeSpillFile(o.spillPath, entries[n:]); err != nil {
+		log.Errorf("write outbox %s %v", o.spillPath, err)
+		return
+	}
+	o.entries = append(o.entries, entries[:n]...)
+	o.spilled = len(entries) - n
+}
+
+func (o *outbox) ack(sender string, seq uint64) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	k := slices.IndexFunc(o.entries, func(entry *outboxEntry) bool {
+		return entry.Seq == seq && entry.Sender == sender
+	})
+	if k < 0 {
+		return false
+	}
+	o.entries = slices.Delete(o.entries, k, k+1)
+	o.refill()
+	return true
+}
+
+// 需要发送的消息：未发送或超时未确认。isAll为true时返回所有的消息
+func (o *outbox) due(now time.Time, isAll bool) [][]byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var bufs [][]byte
+	for _, entry := range o.entries {
+		if isAll || now.Sub(entry.sendTime) >= reliableAckTimeout {
+			buf, err := entry.encode(o.acked(entry.Sender, entry.Seq))
+			if err != nil {
+				log.Errorf("encode reliable message %s %d %v", entry.Sender, entry.Seq, err)
+				continue
+			}
+			entry.sendTime = now
+			bufs = append(bufs, buf)
+		}
+	}
+	return bufs
+}
+
+func (o *outbox) len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries) + o.spilled
+}
+
+// 接收方检查可靠消息的结果
+const (
+	reliableAccept = iota // 按序的新消息，处理后确认
+	reliableDone          // 已处理的消息，再次确认
+	reliableIgnore        // 处理中或序号不连续的消息，不处理也不确认
+)
+
+// 接收方的去重窗口，按序号顺序接收
+type dedupeWindow struct {
+	base    uint64 // 小于等于base的序号均已接收
+	done    uint64 // 小于等于done的序号均已处理
+	lastUse time.Time
+}
+
+// acked为发送方已确认的序号
+func (w *dedupeWindow) check(seq, acked uint64) int {
+	w.base = max(w.base, acked)
+	w.done = max(w.done, acked)
+	switch {
+	case seq <= w.done:
+		return reliableDone
+	case seq == w.base+1:
+		w.base = seq
+		return reliableAccept
+	}
+	return reliableIgnore
+}
+
+// 可靠消息的状态，在CmdSet中使用
+type reliableState struct {
+	once   sync.Once
+	sender string // 发送方ID，进程内唯一
+
+	outboxDir      string // 磁盘缓存的目录。为空时仅保存在内存中
+	outboxMemLimit int
+
+	dedupe          map[string]*dedupeWindow // [sender:window]
+	dedupeMu        sync.Mutex
+	dedupeCleanTime time.Time
+}
+
+func (s *CmdSet) initReliable() {
+	s.reliable.once.Do(func() {
+		s.reliable.sender = utils.GUID()
+		s.Bind(reliableAckMsgId, s.funcReliableAck, (*reliableAckArgs)(nil), WithPrivate())
+	})
+}
+
+// 每个目标的消息使用独立的发送方ID。name为经路由转发的服务名
+func (s *CmdSet) reliableSender(serverId, name string) string {
+	s.initReliable()
+	return s.reliable.sender + ":" + serverId + ":" + name
+}
+
+func (s *CmdSet) outboxSpillPath(serverId string) string {
+	if s.reliable.outboxDir == "" {
+		return ""
+	}
+	name := outboxFilePrefix + reliableServerIdRep.Replace(serverId) + outboxFileExt
+	return filepath.Join(s.reliable.outboxDir, name)
+}
+
+// 设置可靠消息的磁盘缓存，需在发送消息前设置。memLimit为每个目标服务在内存中保存的消息数
+// 加载目录中上次运行未确认的消息，连接目标服务后重发
+func SetOutboxSpill(dir string, memLimit int) error {
+	return defaultCmdSet.SetOutboxSpill(dir, memLimit)
+}
+
+func (s *CmdSet) SetOutboxSpill(dir string, memLimit int) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	s.initReliable()
+	s.reliable.outboxDir = dir
+	s.reliable.outboxMemLimit = memLimit
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, outboxFilePrefix) || !strings.HasSuffix(name, outboxFileExt) {
+			continue
+		}
+		serverId := strings.TrimSuffix(strings.TrimPrefix(name, outboxFilePrefix), outboxFileExt)
+		if client := s.client(serverId); client.outbox.len() > 0 {
+			log.Infof("load outbox %s %d messages", serverId, client.outbox.len())
+		}
+	}
+	return nil
+}
+
+func (s *CmdSet) sendReliable(serverId string, entry *outboxEntry) error {
+	// 录制的消息不含序号，回放时仅录制不发送
+	if s.recorder.Load() != nil {
+		record := &outboxEntry{MsgId: entry.MsgId, Data: entry.Data, ServerName: entry.ServerName}
+		if buf, err := record.encode(0); err == nil {
+			s.recordOut(serverId, buf)
+		}
+	}
+	if s.isReplaying.Load() {
+		return nil
+	}
+
+	client := s.client(serverId)
+	buf, err := client.outbox.add(entry)
+	if err != nil {
+		return err
+	}
+	// 写入失败时等待超时重发
+	if buf != nil {
+		if err := client.Write(buf); err != nil {
+			log.Warnf("server %s write reliable message %d error: %v", serverId, entry.Seq, err)
+		}
+	}
+	return nil
+}
+
+// 可靠地发送消息到服务，未确认时重发
+func RouteReliable(serverId, msgId string, i any) error {
+	return defaultCmdSet.RouteReliable(serverId, msgId, i)
+}
+
+func (s *CmdSet) RouteReliable(serverId, msgId string, i any) error {
+	data, err := marshalJSON(i)
+	if err != nil {
+		return err
+	}
+	return s.sendReliable(serverId, &outboxEntry{Sender: s.reliableSender(serverId, ""), MsgId: msgId, Data: data})
+}
+
+// 经路由可靠地转发消息，需先注册服务。多个服务匹配时任一服务确认即完成
+func ForwardReliable(name, msgId string, i any) error {
+	return defaultCmdSet.ForwardReliable(name, msgId, i)
+}
+
+func (s *CmdSet) ForwardReliable(name, msgId string, i any) error {
+	var replyTo string
+	if client, ok := s.clients.Load("router"); ok {
+		replyTo = client.(*Client).conf.Id
+	}
+	if replyTo == "" && !s.isReplaying.Load() {
+		return errNotRegistered
+	}
+
+	data, err := marshalJSON(i)
+	if err != nil {
+		return err
+	}
+	entry := &outboxEntry{Sender: s.reliableSender("router", name), MsgId: msgId, Data: data, ServerName: name, ReplyTo: replyTo}
+	return s.sendReliable("router", entry)
+}
+
+func (s *CmdSet) funcReliableAck(ctx *Context, data any) {
+	args := data.(*reliableAckArgs)
+	s.clients.Range(func(_, v any) bool {
+		return !v.(*Client).outbox.ack(args.Sender, args.Seq)
+	})
+}
+
+// 接收方检查可靠消息是否需要处理
+func (s *CmdSet) checkReliable(sender string, seq, acked uint64) int {
+	r := &s.reliable
+	r.dedupeMu.Lock()
+	defer r.dedupeMu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.dedupeCleanTime) > time.Minute {
+		r.dedupeCleanTime = now
+		for k, w := range r.dedupe {
+			if now.Sub(w.lastUse) > dedupeIdleTime {
+				delete(r.dedupe, k)
+			}
+		}
+	}
+	if r.dedupe == nil {
+		r.dedupe = map[string]*dedupeWindow{}
+	}
+	w, ok := r.dedupe[sender]
+	if !ok {
+		w = &dedupeWindow{}
+		r.dedupe[sender] = w
+	}
+	w.lastUse = now
+	return w.check(seq, acked)
+}
+
+// 接收方已处理可靠消息
+func (s *CmdSet) doneReliable(sender string, seq uint64) {
+	r := &s.reliable
+	r.dedupeMu.Lock()
+	defer r.dedupeMu.Unlock()
+
+	if w, ok := r.dedupe[sender]; ok {
+		w.done = max(w.done, seq)
+	}
+}
+
+// 回复可靠消息已处理，仅回复一次
+func (ctx *Context) ackReliable() {
+	if ctx.seq == 0 {
+		return
+	}
+	args := &reliableAckArgs{Sender: ctx.sender, Seq: ctx.seq}
+	ctx.seq = 0
+	ctx.CmdSet().doneReliable(args.Sender, args.Seq)
+	if ctx.replyTo == "" {
+		ctx.Out.WriteJSON(reliableAckMsgId, args)
+		return
+	}
+	data, _ := json.Marshal(args)
+	ctx.CmdSet().Route("router", "c2s_route", &forwardArgs{ServerId: ctx.replyTo, MsgId: reliableAckMsgId, MsgData: data})
+}
+
+// 重发未确认的消息。isAll为true时发送所有的消息，用于重连后
+func (c *Client) resendReliable(isAll bool) error {
+	for _, buf := range c.outbox.due(time.Now(), isAll) {
+		if _, err := c.writeMsg(RawMessage, buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package cmd
+
+// 消息处理后统一格式回复
+// 成功：{"data":{...}}
+// 失败：{"code":"system_error","msg":"..."}，错误码同api包
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+const (
+	CodeSystemError  = "system_error"
+	CodeInvalidArgs  = "invalid_args"
+	CodeUnauthorized = "unauthorized" // 认证失败
+)
+
+type Error struct {
+	Code string `json:"code,omitempty"`
+	Msg  string `json:"msg,omitempty"`
+}
+
+func NewError(code, msg string) *Error {
+	return &Error{Code: code, Msg: msg}
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Msg
+}
+
+type Reply struct {
+	Code string `json:"code,omitempty"`
+	Msg  string `json:"msg,omitempty"`
+	Data any    `json:"data,omitempty"`
+}
+
+func newReply(data any, err error) *Reply {
+	if err == nil {
+		return &Reply{Data: data}
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return &Reply{Code: e.Code, Msg: e.Msg}
+	}
+	return &Reply{Code: CodeSystemError, Msg: err.Error()}
+}
+
+// 默认回复的消息ID。c2s_xxx => s2c_xxx
+func replyMsgId(name string) string {
+	if len(name) > 4 && strings.EqualFold(name[:4], "c2s_") {
+		name = name[4:]
+	}
+	return "s2c_" + name
+}
+
+type routeArgs struct {
+	Id   string          `json:"id,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// 回复发送方。服务收到经网关转发的客户端消息时，通过网关的func_route回复客户端
+func (ctx *Context) Reply(msgId string, data any) error {
+	if ctx.Out == nil {
+		return errors.New("reply to empty connection")
+	}
+	if ctx.Ssid != "" {
+		if ss := ctx.CmdSet().GetSession(ctx.Ssid); ss == nil || ss.Out != ctx.Out {
+			buf, err := marshalJSON(data)
+			if err != nil {
+				return err
+			}
+			pkg := &Package{Id: "func_route", Ssid: ctx.Ssid, Body: routeArgs{Id: msgId, Data: buf}}
+			buf, err = EncodePackage(pkg)
+			if err != nil {
+				return err
+			}
+			return ctx.Out.Write(buf)
+		}
+	}
+	return ctx.Out.WriteJSON(msgId, data)
+}
+
+func withReplyType(t reflect.Type) bindOptionFunc {
+	return func(opt *bindOption) {
+		opt.replyType = t
+	}
+}
+
+// 处理消息并返回结果，框架统一回复
+type TypedHandler[Req, Resp any] func(*Context, *Req) (Resp, error)
+
+func BindTyped[Req, Resp any](name string, h TypedHandler[Req, Resp], opt ...bindOptionFunc) {
+	BindTypedTo(defaultCmdSet, name, h, opt...)
+}
+
+// 泛型不支持方法，指定CmdSet绑定
+func BindTypedTo[Req, Resp any](s *CmdSet, name string, h TypedHandler[Req, Resp], opt ...bindOptionFunc) {
+	replyOpt := &bindOption{replyId: replyMsgId(name)}
+	for _, fn := range opt {
+		fn(replyOpt)
+	}
+	replyId := replyOpt.replyId
+
+	replyType := reflect.TypeOf((*Resp)(nil)).Elem()
+	opt = append([]bindOptionFunc{WithReply(replyId), withReplyType(replyType)}, opt...)
+	s.Bind(name, func(ctx *Context, data any) {
+		resp, err := h(ctx, data.(*Req))
+		ctx.Reply(replyId, newReply(resp, err))
+	}, (*Req)(nil), opt...)
+}
@@ -0,0 +1,247 @@
+package cmd
+
+// 协议描述。根据绑定的消息参数类型生成JSON Schema
+// 可通过消息c2s_schema或HTTP查询进程内所有的消息
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const jsonSchemaVersion = "https://json-schema.org/draft/2020-12/schema"
+
+type JSONSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+
+	Order []string `json:"x-order,omitempty"` // 字段的定义顺序，用于生成代码
+}
+
+type RouteInfo struct {
+	Id         string      `json:"id"`
+	ServerName string      `json:"serverName,omitempty"` // 限定请求的服务名
+	IsPrivate  bool        `json:"isPrivate,omitempty"`  // 内部消息
+	ReplyId    string      `json:"replyId,omitempty"`
+	Args       *JSONSchema `json:"args,omitempty"`
+	Reply      *JSONSchema `json:"reply,omitempty"` // 回复Reply.Data的数据
+}
+
+type SchemaDoc struct {
+	Schema string                 `json:"$schema"`
+	Routes []RouteInfo            `json:"routes"`
+	Defs   map[string]*JSONSchema `json:"$defs,omitempty"`
+}
+
+type schemaBuilder struct {
+	defs  map[string]*JSONSchema
+	names map[reflect.Type]string
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// 具名结构体放入$defs，同名不同包时加上包名，包名也相同时再加序号
+func (b *schemaBuilder) defName(t reflect.Type) string {
+	if name, ok := b.names[t]; ok {
+		return name
+	}
+	used := map[string]bool{}
+	for _, name := range b.names {
+		used[name] = true
+	}
+	name := t.Name()
+	if used[name] {
+		name = path.Base(t.PkgPath()) + t.Name()
+	}
+	for i := 2; used[name]; i++ {
+		name = path.Base(t.PkgPath()) + t.Name() + strconv.Itoa(i)
+	}
+	b.names[t] = name
+	return name
+}
+
+func (b *schemaBuilder) build(t reflect.Type) *JSONSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		return &JSONSchema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &JSONSchema{Type: "string", Format: "byte"}
+		}
+		return &JSONSchema{Type: "array", Items: b.build(t.Elem())}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", AdditionalProperties: b.build(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.buildStruct(t)
+		}
+		name := b.defName(t)
+		if _, ok := b.defs[name]; !ok {
+			b.defs[name] = &JSONSchema{} // 防止递归类型死循环
+			*b.defs[name] = *b.buildStruct(t)
+		}
+		return &JSONSchema{Ref: "#/$defs/" + name}
+	}
+	// interface等任意类型
+	return &JSONSchema{}
+}
+
+func (b *schemaBuilder) buildStruct(t reflect.Type) *JSONSchema {
+	schema := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}}
+	b.addFields(schema, t)
+	return schema
+}
+
+func (b *schemaBuilder) addFields(schema *JSONSchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		// 匿名结构体字段展开，同encoding/json
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				b.addFields(schema, ft)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		prop := b.build(field.Type)
+		for _, rule := range strings.Split(field.Tag.Get("binding"), ",") {
+			key, value, _ := strings.Cut(rule, "=")
+			switch key {
+			case "required":
+				schema.Required = append(schema.Required, name)
+			case "min", "max":
+				if prop.Type != "integer" && prop.Type != "number" {
+					break
+				}
+				if n, err := strconv.ParseFloat(value, 64); err == nil {
+					if key == "min" {
+						prop.Minimum = &n
+					} else {
+						prop.Maximum = &n
+					}
+				}
+			}
+		}
+		if _, ok := schema.Properties[name]; !ok {
+			schema.Order = append(schema.Order, name)
+		}
+		schema.Properties[name] = prop
+	}
+}
+
+// 生成所有消息的协议描述
+func (s *CmdSet) Schema() *SchemaDoc {
+	s.mu.RLock()
+	entries := make([]*cmdEntry, 0, len(s.table))
+	for _, e := range s.table {
+		entries = append(entries, e)
+	}
+	s.mu.RUnlock()
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].name != entries[j].name {
+			return entries[i].name < entries[j].name
+		}
+		return entries[i].serverName < entries[j].serverName
+	})
+
+	b := &schemaBuilder{defs: map[string]*JSONSchema{}, names: map[reflect.Type]string{}}
+	doc := &SchemaDoc{Schema: jsonSchemaVersion, Routes: []RouteInfo{}}
+	for _, e := range entries {
+		route := RouteInfo{
+			Id:         e.name,
+			ServerName: e.serverName,
+			IsPrivate:  e.isPrivate,
+			ReplyId:    e.replyId,
+		}
+		if e.type_ != nil {
+			route.Args = b.build(e.type_)
+		}
+		if e.replyType != nil {
+			route.Reply = b.build(e.replyType)
+		}
+		doc.Routes = append(doc.Routes, route)
+	}
+	doc.Defs = b.defs
+	return doc
+}
+
+func Schema() *SchemaDoc {
+	return defaultCmdSet.Schema()
+}
+
+type schemaArgs struct{}
+
+// 绑定内部消息c2s_schema查询协议描述
+func (s *CmdSet) BindSchema() {
+	BindTypedTo(s, "c2s_schema", func(ctx *Context, args *schemaArgs) (*SchemaDoc, error) {
+		return s.Schema(), nil
+	}, WithPrivate())
+}
+
+func BindSchema() {
+	defaultCmdSet.BindSchema()
+}
+
+type schemaHandler struct {
+	set *CmdSet
+}
+
+func (h schemaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.set.Schema())
+}
+
+// HTTP查询协议描述。set为空时使用默认的CmdSet
+func SchemaHandler(set *CmdSet) http.Handler {
+	if set == nil {
+		set = defaultCmdSet
+	}
+	return schemaHandler{set: set}
+}
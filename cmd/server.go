@@ -10,7 +10,15 @@ import (
 )
 
 type Server struct {
-	Addr string
+	Addr   string
+	CmdSet *CmdSet // 处理连接的消息。为空时使用默认的CmdSet
+}
+
+func (srv *Server) cmdSet() *CmdSet {
+	if srv.CmdSet == nil {
+		return defaultCmdSet
+	}
+	return srv.CmdSet
 }
 
 func (srv *Server) Serve(l net.Listener) error {
@@ -37,16 +45,11 @@ func (srv *Server) Serve(l net.Listener) error {
 
 		ssid := utils.GUID()
 		c := &ServeConn{
-			server: srv,
-			TCPConn: &TCPConn{
-				ssid: ssid,
-				rwc:  rwc,
-				send: make(chan []byte, 32<<10),
-				pong: make(chan bool, 1),
-			},
+			server:  srv,
+			TCPConn: NewTCPConn(rwc, ssid),
 		}
 		// log.Info("create guid", ssid)
-		AddSession(&Session{Id: ssid, Out: c})
+		srv.cmdSet().AddSession(&Session{Id: ssid, Out: c})
 		go c.serve()
 	}
 }
@@ -70,37 +73,32 @@ type ServeConn struct {
 	*TCPConn
 }
 
+func (c *ServeConn) WriteJSON(name string, i any) error {
+	buf, err := EncodePackage(&Package{Id: name, Body: i})
+	if err != nil {
+		return err
+	}
+	return c.Write(buf)
+}
+
+// 开启录制时记录发出的消息
+func (c *ServeConn) Write(buf []byte) error {
+	c.server.cmdSet().recordOut("", buf)
+	return c.TCPConn.Write(buf)
+}
+
 func (c *ServeConn) serve() {
 	doneCtx, cancel := context.WithCancel(context.Background())
 	go func() {
 		defer func() {
 			c.Close() // 关闭网络连接
 
-			RemoveSession(c.ssid) // 删除会话
-			defaultCmdSet.Handle(&Context{Ssid: c.ssid, Out: c}, "func_close", nil)
+			set := c.server.cmdSet()
+			set.RemoveSession(c.ssid) // 删除会话
+			set.Handle(&Context{Ssid: c.ssid, Out: c}, "func_close", nil)
 		}()
 
-		for {
-			select {
-			case buf, ok := <-c.send:
-				if !ok {
-					return
-				}
-				// 忽略过大消息
-				if _, err := c.writeMsg(RawMessage, buf); err != nil {
-					log.Debugf("write %v", err)
-					if err != errTooLargeMessage {
-						return
-					}
-				}
-			case <-c.pong:
-				if _, err := c.writeMsg(PongMessage, []byte{}); err != nil {
-					return
-				}
-			case <-doneCtx.Done():
-				return
-			}
-		}
+		c.WriteLoop(doneCtx)
 	}()
 
 	defer func() {
@@ -113,14 +111,15 @@ func (c *ServeConn) serve() {
 	c.rwc.SetReadDeadline(time.Now().Add(5 * time.Second))
 
 	// 第一个包校验数据安全
-	codec := authCodec
+	auth := authCodec.Load()
+	codec := auth
 	for {
 		mt, buf, err := c.ReadMessage()
 		if err != nil {
 			return
 		}
 
-		isAuth := (codec == authCodec)
+		isAuth := (codec == auth)
 		if isAuth {
 			c.rwc.SetReadDeadline(time.Now().Add(pongWait))
 		}
@@ -137,8 +136,13 @@ func (c *ServeConn) serve() {
 					Ssid:       pkg.Ssid,
 					ServerName: pkg.ServerName,
 					ClientAddr: pkg.ClientAddr,
+					UserId:     pkg.UserId,
+					seq:        pkg.Seq,
+					acked:      pkg.Acked,
+					sender:     pkg.Sender,
+					replyTo:    pkg.ReplyTo,
 				}
-				if err := defaultCmdSet.Handle(ctx, pkg.Id, pkg.Data); err != nil {
+				if err := c.server.cmdSet().Handle(ctx, pkg.Id, pkg.Data); err != nil {
 					log.Debugf("handle msg[%s] error: %v", buf, err)
 				}
 			}
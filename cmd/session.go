@@ -1,40 +1,53 @@
 package cmd
 
-import (
-	"sync"
-)
-
 type Session struct {
-	Id  string
-	Out Conn
+	Id     string
+	Out    Conn
+	UserId string // 网关认证后的用户ID，转发消息时携带
+
+	set *CmdSet
+}
+
+func (ss *Session) cmdSet() *CmdSet {
+	if ss.set == nil {
+		return defaultCmdSet
+	}
+	return ss.set
 }
 
+// 转发消息，用户ID优先取Context中的
 func (ss *Session) routeContext(ctx *Context, msgId string, msgData any) {
+	userId := ctx.UserId
+	if userId == "" {
+		userId = ss.UserId
+	}
 	pkg := &Package{
 		Id:         msgId,
 		Body:       msgData,
 		Ssid:       ss.Id,
 		ServerName: ctx.ServerName,
 		ClientAddr: ctx.ClientAddr,
+		UserId:     userId,
 	}
 	buf, err := EncodePackage(pkg)
 	if err != nil {
 		return
 	}
-	routeMsg(ctx.MatchServer, buf)
+	ss.cmdSet().routeMsg(ctx.MatchServer, buf)
 }
 
 func (ss *Session) Route(serverId, msgId string, msgData any) {
 	pkg := &Package{
-		Id:   msgId,
-		Ssid: ss.Id,
-		Body: msgData,
+		Id:     msgId,
+		Ssid:   ss.Id,
+		Body:   msgData,
+		UserId: ss.UserId,
 	}
 	buf, err := EncodePackage(pkg)
 	if err != nil {
 		return
 	}
-	routeMsg(serverId, buf)
+	ss.cmdSet().routeMsg(serverId, buf)
 }
 
 func (ss *Session) WriteJSON(msgId string, msgData any) {
@@ -46,45 +59,68 @@ func (ss *Session) WriteJSON(msgId string, msgData any) {
 	ss.Out.Write(buf)
 }
 
-var (
-	sessions  = map[string]*Session{}
-	sessionMu sync.RWMutex
-)
+// 创建经当前CmdSet转发消息的会话，不加入会话列表
+func (s *CmdSet) NewSession(id string, out Conn) *Session {
+	return &Session{Id: id, Out: out, set: s}
+}
 
-func AddSession(s *Session) {
-	sessionMu.Lock()
-	defer sessionMu.Unlock()
-	if _, ok := sessions[s.Id]; ok {
-		panic("add same session " + s.Id)
+func (s *CmdSet) AddSession(ss *Session) {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	if _, ok := s.sessions[ss.Id]; ok {
+		panic("add same session " + ss.Id)
+	}
+	if ss.set == nil {
+		ss.set = s
 	}
-	sessions[s.Id] = s
+	s.sessions[ss.Id] = ss
 }
 
-func RemoveSession(id string) {
-	sessionMu.Lock()
-	defer sessionMu.Unlock()
-	delete(sessions, id)
+func (s *CmdSet) RemoveSession(id string) {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	delete(s.sessions, id)
 }
 
-func GetSession(id string) *Session {
-	sessionMu.RLock()
-	defer sessionMu.RUnlock()
-	return sessions[id]
+func (s *CmdSet) GetSession(id string) *Session {
+	s.sessionMu.RLock()
+	defer s.sessionMu.RUnlock()
+	return s.sessions[id]
 }
 
-func GetSessionList() []*Session {
-	sessionMu.RLock()
-	defer sessionMu.RUnlock()
+func (s *CmdSet) GetSessionList() []*Session {
+	s.sessionMu.RLock()
+	defer s.sessionMu.RUnlock()
 
 	var all []*Session
-	for _, ss := range sessions {
+	for _, ss := range s.sessions {
 		all = append(all, ss)
 	}
 	return all
 }
 
+func (s *CmdSet) CountSession() int {
+	s.sessionMu.RLock()
+	defer s.sessionMu.RUnlock()
+	return len(s.sessions)
+}
+
+func AddSession(ss *Session) {
+	defaultCmdSet.AddSession(ss)
+}
+
+func RemoveSession(id string) {
+	defaultCmdSet.RemoveSession(id)
+}
+
+func GetSession(id string) *Session {
+	return defaultCmdSet.GetSession(id)
+}
+
+func GetSessionList() []*Session {
+	return defaultCmdSet.GetSessionList()
+}
+
 func CountSession() int {
-	sessionMu.RLock()
-	defer sessionMu.RUnlock()
-	return len(sessions)
+	return defaultCmdSet.CountSession()
 }
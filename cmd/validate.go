@@ -0,0 +1,31 @@
+package cmd
+
+// 消息参数校验，规则同api包，如`binding:"required,min=1"`
+
+import (
+	"reflect"
+
+	"github.com/go-playground/validator/v10"
+)
+
+var argsValidator = newArgsValidator()
+
+func newArgsValidator() *validator.Validate {
+	v := validator.New()
+	v.SetTagName("binding")
+	return v
+}
+
+func validateArgs(args any) error {
+	t := reflect.TypeOf(args)
+	if t == nil {
+		return nil
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	return argsValidator.Struct(args)
+}
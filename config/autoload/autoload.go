@@ -0,0 +1,15 @@
+// 导入后自动解析命令行参数并加载配置，兼容旧版本config包的初始化行为
+//
+//	import _ "github.com/guogeer/quasar/v2/config/autoload"
+package autoload
+
+import (
+	"github.com/guogeer/quasar/v2/config"
+	"github.com/guogeer/quasar/v2/log"
+)
+
+func init() {
+	if err := config.ParseCommandLine(); err != nil {
+		log.Error(err)
+	}
+}
@@ -1,15 +1,18 @@
 package config
 
 // 进程启动时预加载的配置
-// 默认获取进程工作路径下config.xml
+// 默认获取进程工作路径下config.yaml
+// 加载顺序，后者覆盖前者：基础配置文件>环境配置文件>环境变量QUASAR_*>命令行参数
 
 import (
 	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"flag"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 
 	"github.com/guogeer/quasar/v2/log"
 	"gopkg.in/yaml.v3"
@@ -21,6 +24,11 @@ func LoadFile(path string, conf any) error {
 	if err != nil {
 		return err
 	}
+	return unmarshalFile(path, b, conf)
+}
+
+func unmarshalFile(path string, b []byte, conf any) error {
+	var err error
 	switch filepath.Ext(path) {
 	default:
 		return errors.New("only support xml|json|yaml")
@@ -40,22 +48,86 @@ type server struct {
 }
 
 type Env struct {
-	path string
+	path    string
+	envName string
+	flags   map[string]string // 命令行显式指定的参数
 
-	ServerKey  string   `yaml:"serverKey"`
-	ClientKey  string   `yaml:"clientKey"`
-	ServerList []server `yaml:"serverList" xml:"ServerList>Server"`
+	ServerKey  string     `yaml:"serverKey"`
+	ClientKey  string     `yaml:"clientKey"`
+	ServerList serverList `yaml:"serverList" xml:"ServerList>Server"`
 	Log        struct {
 		Path  string `yaml:"path" long:"log-path" default:"DEBUG" description:"log DEBUG|INFO|ERROR"`
 		Level string `yaml:"level"  long:"log-level" default:"log/{proc_name}/run.log" description:"log file path"`
 	} `yaml:"log"`
-	EnableDebug bool `yaml:"enableDebug"` // 开启调试，将输出消息统计日志等
+	EnableDebug bool            `yaml:"enableDebug"` // 开启调试，将输出消息统计日志等
+	RateLimit   RateLimitConfig `yaml:"rateLimit"`   // 网关客户端消息限流
+	Auth        AuthConfig      `yaml:"auth"`        // 网关客户端认证
+	Resume      ResumeConfig    `yaml:"resume"`      // 网关断线重连恢复会话
+	WebSocket   WebSocketConfig `yaml:"webSocket"`   // 网关WebSocket压缩、合并发送
+	Access      AccessConfig    `yaml:"access"`      // 网关客户端访问控制
+	SendQueue   SendQueueConfig `yaml:"sendQueue"`   // 网关WebSocket客户端的发送队列
+}
+
+// 发送队列。客户端网络慢时队列满，按policy处理新消息
+type SendQueueConfig struct {
+	Size              int        `yaml:"size"`              // 每个连接的队列长度。默认1024
+	Policy            string     `yaml:"policy"`            // 队列满时：dropNewest丢弃新消息|dropOldest丢弃最早的消息|disconnect断开连接。默认dropNewest
+	SaturationTimeout int        `yaml:"saturationTimeout"` // 队列持续满的秒数，超过后断开连接。为0时不断开
+	Coalesce          stringList `yaml:"coalesce"`          // 合并的消息ID，队列中未发送的同ID消息仅保留最新的
+}
+
+// 网关客户端访问控制。IP支持单个地址或CIDR，如10.0.0.0/8
+type AccessConfig struct {
+	Origins        stringList `yaml:"origins"`        // 允许的WebSocket Origin，如https://example.com、*.example.com。为空时不检查
+	Allow          stringList `yaml:"allow"`          // 允许的IP。为空时允许所有
+	Deny           stringList `yaml:"deny"`           // 拒绝的IP，优先于allow
+	MaxConns       int        `yaml:"maxConns"`       // 网关最大连接数。为0时不限制
+	MaxConnsPerIP  int        `yaml:"maxConnsPerIP"`  // 每个IP的最大连接数。为0时不限制
+	TrustedProxies stringList `yaml:"trustedProxies"` // 可信的代理IP，仅信任来自代理的X-Forwarded-For及PROXY协议
+	ProxyProtocol  bool       `yaml:"proxyProtocol"`  // TCP、KCP连接来自可信代理时读取PROXY协议头
+}
+
+type WebSocketConfig struct {
+	Compress          bool `yaml:"compress"`          // 开启permessage-deflate压缩
+	CompressThreshold int  `yaml:"compressThreshold"` // 消息超过字节数时压缩。默认512
+	CompressLevel     int  `yaml:"compressLevel"`     // 压缩等级1~9。默认1
+	BatchSize         int  `yaml:"batchSize"`         // 客户端支持时合并发送的最大消息数。默认16，为1时不合并
+}
+
+type ResumeConfig struct {
+	GracePeriod int `yaml:"gracePeriod"` // 断线后保留会话的秒数。为0时不恢复会话
+	BufferSize  int `yaml:"bufferSize"`  // 断线期间缓存的消息数，超过后不可恢复。默认256
+}
+
+// 网关客户端认证
+type AuthConfig struct {
+	Type    string `yaml:"type"`    // 认证方式：hmac|jwt|service。为空时不认证
+	Key     string `yaml:"key"`     // hmac、jwt校验签名的密钥
+	Service string `yaml:"service"` // 请求登录服务认证的消息，如login.c2s_auth
+}
+
+// 令牌桶限流规则
+type RateLimitRule struct {
+	Rate   float64 `yaml:"rate"`   // 每秒生成的令牌数。为0时不限流
+	Burst  int     `yaml:"burst"`  // 令牌桶容量，未配置时为每秒生成的令牌数
+	Action string  `yaml:"action"` // 超过限制后的处理：warn|drop|disconnect
+}
+
+type RateLimitConfig struct {
+	Session  RateLimitRule            `yaml:"session"`  // 每个会话
+	IP       RateLimitRule            `yaml:"ip"`       // 每个IP
+	Messages map[string]RateLimitRule `yaml:"messages"` // 每个会话的单个消息。[msgId:rule]
 }
 
 func (env *Env) Path() string {
 	return env.path
 }
 
+// 当前环境名，如prod、test
+func (env *Env) EnvName() string {
+	return env.envName
+}
+
 func (env *Env) Server(name string) server {
 	for _, srv := range env.ServerList {
 		if srv.Name == name {
@@ -65,34 +137,98 @@ func (env *Env) Server(name string) server {
 	return server{}
 }
 
-var defaultConfig Env
+var defaultConfig atomic.Pointer[Env]
 
+// 当前生效的配置。配置重新加载后返回新的对象
 func Config() *Env {
-	return &defaultConfig
+	return defaultConfig.Load()
 }
 
+// 未加载配置时为空配置，日志仅输出到标准输出
 func init() {
-	conf := Config()
-	// 参数优先级：命令行>配置文件
+	defaultConfig.Store(&Env{})
+}
+
+// 加载配置：默认值<配置文件<环境配置文件<环境变量QUASAR_*
+// 环境名取环境变量QUASAR_ENV。仅返回新配置，不影响当前生效的配置
+func Load(path string) (*Env, error) {
+	return loadEnv(path, os.Getenv(envPrefix+"ENV"), nil)
+}
+
+// 替换当前生效的配置，并通知订阅者更新日志等
+func SetConfig(conf *Env) {
+	setConfig(conf)
+}
+
+// 生成新的配置：默认值<配置文件<环境变量<命令行参数
+func loadEnv(path, envName string, flags map[string]string) (*Env, error) {
+	conf := &Env{path: path, envName: envName, flags: flags}
+	conf.Log.Path = "log/{proc_name}/run.log"
+	conf.Log.Level = "DEBUG"
+
+	var err error
+	if path != "" {
+		err = loadEnvFiles(conf, path, envName)
+	}
+	if err2 := overrideEnvVars(conf, os.LookupEnv); err == nil {
+		err = err2
+	}
+	if v, ok := flags["log-level"]; ok {
+		conf.Log.Level = v
+	}
+	if v, ok := flags["log-path"]; ok {
+		conf.Log.Path = v
+	}
+	return conf, err
+}
+
+// 解析命令行参数并加载配置，同旧版本导入config包时的初始化
+// 参数优先级：命令行>环境变量>环境配置文件>配置文件
+func ParseCommandLine() error {
 	newFlag := flag.NewFlagSet("init config", flag.ContinueOnError)
 	configPath := newFlag.String("config", "config.yaml", "config file path")
-	logLevel := newFlag.String("log-level", "DEBUG", "log DEBUG|INFO|ERROR")
-	logPath := newFlag.String("log-path", "log/{proc_name}/run.log", "log path")
+	envName := newFlag.String("config-env", "", "config environment, load overlay file such as config.{env}.yaml")
+	configDump := newFlag.Bool("config-dump", false, "print the effective config")
+	newFlag.String("log-level", "DEBUG", "log DEBUG|INFO|ERROR")
+	newFlag.String("log-path", "log/{proc_name}/run.log", "log path")
+	// 进程的命令行参数在main中解析，这里仅提取配置相关的参数
+	if err := newFlag.Parse(filterArgs(newFlag, os.Args[1:])); err != nil {
+		return err
+	}
+	registerFlags(newFlag)
+
+	// 命令行显式指定的参数，重新加载配置时仍生效
+	flags := map[string]string{}
+	newFlag.Visit(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
 
-	newFlag.Parse(flag.CommandLine.Args())
-	if *configPath != "" {
-		conf.path = *configPath
+	path := *configPath
+	if v, ok := os.LookupEnv(envPrefix + "CONFIG"); ok && !isFlagSet(newFlag, "config") {
+		path = v
+	}
+	env := os.Getenv(envPrefix + "ENV")
+	if isFlagSet(newFlag, "config-env") {
+		env = *envName
 	}
-	conf.Log.Path = *logPath
-	conf.Log.Level = *logLevel
 
-	if conf.path != "" {
-		err := LoadFile(conf.path, &conf)
-		if err != nil {
-			log.Errorf("load config %s error %v", conf.path, err)
-		}
+	conf, err := loadEnv(path, env, flags)
+	setConfig(conf)
+	if *configDump {
+		fmt.Print(conf.Dump())
 	}
+	if err != nil {
+		return fmt.Errorf("load config %s: %w", path, err)
+	}
+	return nil
+}
 
-	log.Create(conf.Log.Path)
-	log.SetLevel(conf.Log.Level)
+// log包不能依赖config，由config通知日志配置变化
+func applyLogConfig(oldConf, newConf *Env) {
+	if oldConf.Log.Path != newConf.Log.Path {
+		log.Create(newConf.Log.Path)
+	}
+	if oldConf.Log.Level != newConf.Log.Level && newConf.Log.Level != "" {
+		log.SetLevel(newConf.Log.Level)
+	}
 }
@@ -1,6 +1,10 @@
 package config
 
 import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/guogeer/quasar/v2/utils"
@@ -19,3 +23,75 @@ func TestLoadConfig(t *testing.T) {
 		t.Error("not equal")
 	}
 }
+
+func TestLoadEnvOverride(t *testing.T) {
+	t.Setenv("QUASAR_TEST_LOG_LEVEL", "INFO")
+
+	env := &Env{}
+	if err := loadEnvFiles(env, "testdata/config.yaml", "test"); err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]string{
+		"QUASAR_ENABLE_DEBUG": "true",
+		"QUASAR_SERVER_LIST":  "router=127.0.0.1:9004,hall=127.0.0.1:9010",
+		"QUASAR_ACCESS_DENY":  "10.0.0.0/8, 192.168.1.1",
+	}
+	lookup := func(k string) (string, bool) { v, ok := vars[k]; return v, ok }
+	if err := overrideEnvVars(env, lookup); err != nil {
+		t.Fatal(err)
+	}
+
+	if env.ClientKey != "helloworld!" || env.ServerKey != "defaultKey" || env.Log.Level != "INFO" {
+		t.Errorf("load overlay file fail %s", env.Dump())
+	}
+	if !env.EnableDebug || env.Server("hall").Addr != "127.0.0.1:9010" || env.Server("router").Addr != "127.0.0.1:9004" {
+		t.Errorf("override environment variables fail %s", env.Dump())
+	}
+	if len(env.Access.Deny) != 2 || env.Access.Deny[1] != "192.168.1.1" {
+		t.Errorf("override list fail %s", env.Dump())
+	}
+}
+
+func TestFilterArgs(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("config", "", "")
+	fs.Bool("config-dump", false, "")
+	args := filterArgs(fs, []string{"-port", "8201", "-config", "a.yaml", "--config-dump", "-id=gw1"})
+	if strings.Join(args, " ") != "-config a.yaml --config-dump" {
+		t.Errorf("filter args %v", args)
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("enableDebug: false\n"), 0644)
+
+	oldConf := Config()
+	defer setConfig(oldConf)
+	conf, err := loadEnv(path, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setConfig(conf)
+
+	var changes []bool
+	cancel := OnChange(func(oldConf, newConf *Env) {
+		changes = append(changes, oldConf.EnableDebug, newConf.EnableDebug)
+		// 回调中订阅不会死锁
+		OnChange(func(oldConf, newConf *Env) {})()
+	})
+	defer cancel()
+	os.WriteFile(path, []byte("enableDebug: true\n"), 0644)
+	if err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !Config().EnableDebug || len(changes) != 2 || changes[0] || !changes[1] {
+		t.Errorf("reload config fail %v", changes)
+	}
+
+	cancel()
+	Reload()
+	if len(changes) != 2 {
+		t.Errorf("notify after cancel %v", changes)
+	}
+}
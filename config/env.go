@@ -0,0 +1,252 @@
+package config
+
+// 分层加载配置
+// 1、基础配置文件，如config.yaml
+// 2、环境配置文件，如config.prod.yaml。环境名来自参数-config-env或环境变量QUASAR_ENV
+// 3、环境变量，如QUASAR_LOG_LEVEL=INFO覆盖log.level
+// 4、命令行参数
+// 配置文件支持${VAR}、${VAR:-default}引用环境变量
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+const envPrefix = "QUASAR_"
+
+var envVarRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// 替换${VAR}为环境变量，未设置时使用默认值
+func expandEnvVars(b []byte) []byte {
+	return envVarRegexp.ReplaceAllFunc(b, func(match []byte) []byte {
+		subs := envVarRegexp.FindSubmatch(match)
+		if v, ok := os.LookupEnv(string(subs[1])); ok {
+			return []byte(v)
+		}
+		return subs[3]
+	})
+}
+
+// 环境配置文件路径。config.yaml => config.prod.yaml
+func overlayPath(path, envName string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + envName + ext
+}
+
+func loadExpandFile(path string, conf any) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return unmarshalFile(path, expandEnvVars(b), conf)
+}
+
+// 加载基础配置文件与环境配置文件
+// 注：环境配置中的列表会整体覆盖基础配置
+func loadEnvFiles(conf *Env, path, envName string) error {
+	if err := loadExpandFile(path, conf); err != nil {
+		return err
+	}
+	if envName == "" {
+		return nil
+	}
+	err := loadExpandFile(overlayPath(path, envName), conf)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// 驼峰转大写下划线。serverKey => SERVER_KEY
+func envVarName(s string) string {
+	var buf []rune
+	for i, c := range s {
+		if unicode.IsUpper(c) && i > 0 {
+			buf = append(buf, '_')
+		}
+		buf = append(buf, unicode.ToUpper(c))
+	}
+	return string(buf)
+}
+
+// 环境变量覆盖配置，变量名由yaml字段路径生成，如log.level => QUASAR_LOG_LEVEL
+func overrideEnvVars(conf any, lookup func(string) (string, bool)) error {
+	return overrideStructEnvVars(reflect.ValueOf(conf).Elem(), envPrefix, lookup)
+}
+
+func overrideStructEnvVars(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if tag == "-" {
+			continue
+		}
+		if tag == "" {
+			tag = field.Name
+		}
+		name := prefix + envVarName(tag)
+
+		fv := v.Field(i)
+		if scanner, ok := fv.Addr().Interface().(Scanner); ok {
+			if s, ok := lookup(name); ok {
+				if err := scanner.Scan(s); err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+			}
+			continue
+		}
+		if fv.Kind() == reflect.Struct {
+			if err := overrideStructEnvVars(fv, name+"_", lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		s, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		switch fv.Kind() {
+		case reflect.String:
+			fv.SetString(s)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			fv.SetBool(b)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			fv.SetInt(n)
+		case reflect.Float32, reflect.Float64:
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			fv.SetFloat(f)
+		default:
+			return fmt.Errorf("%s: %w", name, errUnsupportType)
+		}
+	}
+	return nil
+}
+
+type serverList []server
+
+// 格式：router=127.0.0.1:9003,hall=127.0.0.1:9010
+func (list *serverList) Scan(s string) error {
+	var newList serverList
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, addr, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("invalid server %q", item)
+		}
+		newList = append(newList, server{Name: name, Addr: addr})
+	}
+	*list = newList
+	return nil
+}
+
+type stringList []string
+
+// 格式：10.0.0.0/8,192.168.1.1
+func (list *stringList) Scan(s string) error {
+	var newList stringList
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			newList = append(newList, item)
+		}
+	}
+	*list = newList
+	return nil
+}
+
+// 仅保留FlagSet中定义的参数，忽略进程的其他参数
+func filterArgs(fs *flag.FlagSet, args []string) []string {
+	var matchArgs []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg || name == "" {
+			continue
+		}
+		name, _, hasValue := strings.Cut(name, "=")
+		f := fs.Lookup(name)
+		if f == nil {
+			continue
+		}
+		matchArgs = append(matchArgs, arg)
+
+		isBool := false
+		if bf, ok := f.Value.(interface{ IsBoolFlag() bool }); ok {
+			isBool = bf.IsBoolFlag()
+		}
+		if !hasValue && !isBool && i+1 < len(args) {
+			i++
+			matchArgs = append(matchArgs, args[i])
+		}
+	}
+	return matchArgs
+}
+
+// 注册到默认的命令行参数，避免main中flag.Parse报未定义的参数
+func registerFlags(fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if flag.CommandLine.Lookup(f.Name) == nil {
+			flag.CommandLine.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+}
+
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	var isSet bool
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			isSet = true
+		}
+	})
+	return isSet
+}
+
+func maskSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "******"
+}
+
+// 输出合并后生效的配置，用于调试。密钥已隐藏
+func (env *Env) Dump() string {
+	copyEnv := *env
+	copyEnv.ServerKey = maskSecret(copyEnv.ServerKey)
+	copyEnv.ClientKey = maskSecret(copyEnv.ClientKey)
+	copyEnv.Auth.Key = maskSecret(copyEnv.Auth.Key)
+	b, err := yaml.Marshal(&copyEnv)
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("# config: %s env: %s\n%s", env.path, env.envName, b)
+}
@@ -0,0 +1,120 @@
+package config
+
+// 配置热更新
+// 配置文件变化或进程收到SIGHUP信号时重新加载，并通知订阅者
+
+import (
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/guogeer/quasar/v2/log"
+)
+
+const watchInterval = 3 * time.Second
+
+type changeListener struct {
+	f func(oldConf, newConf *Env)
+}
+
+var (
+	changeListeners = []*changeListener{{f: applyLogConfig}}
+	changeMu        sync.Mutex // 保护订阅者列表
+	setMu           sync.Mutex // 保证通知顺序与配置替换顺序一致
+	watchOnce       sync.Once
+)
+
+// 订阅配置变化。回调在加载配置的协程中执行，回调中可再订阅
+// 返回取消订阅的函数
+func OnChange(f func(oldConf, newConf *Env)) func() {
+	l := &changeListener{f: f}
+	changeMu.Lock()
+	defer changeMu.Unlock()
+	changeListeners = append(changeListeners, l)
+	return func() {
+		changeMu.Lock()
+		defer changeMu.Unlock()
+		changeListeners = slices.DeleteFunc(changeListeners, func(l2 *changeListener) bool { return l2 == l })
+	}
+}
+
+// 替换当前配置并通知订阅者。通知时不持有订阅者列表的锁
+func setConfig(conf *Env) {
+	setMu.Lock()
+	defer setMu.Unlock()
+
+	oldConf := defaultConfig.Swap(conf)
+	changeMu.Lock()
+	listeners := slices.Clone(changeListeners)
+	changeMu.Unlock()
+	for _, l := range listeners {
+		l.f(oldConf, conf)
+	}
+}
+
+// 重新加载配置文件，加载失败时保留旧配置
+func Reload() error {
+	oldConf := Config()
+	conf, err := loadEnv(oldConf.path, oldConf.envName, oldConf.flags)
+	if err != nil {
+		return err
+	}
+	setConfig(conf)
+	return nil
+}
+
+func modTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return stat.ModTime()
+}
+
+// 配置文件及环境配置文件最近的修改时间
+func configModTime(conf *Env) time.Time {
+	t := modTime(conf.path)
+	if conf.envName != "" {
+		if t2 := modTime(overlayPath(conf.path, conf.envName)); t2.After(t) {
+			t = t2
+		}
+	}
+	return t
+}
+
+// 监听配置变化，重复调用无效
+func Watch() {
+	watchOnce.Do(func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGHUP)
+
+		go func() {
+			ticker := time.NewTicker(watchInterval)
+			defer ticker.Stop()
+
+			lastModTime := configModTime(Config())
+			for {
+				select {
+				case <-sig:
+					log.Infof("recv signal SIGHUP, reload config %s", Config().path)
+				case <-ticker.C:
+					if configModTime(Config()).Equal(lastModTime) {
+						continue
+					}
+					log.Infof("config %s changed, reload", Config().path)
+				}
+				// 信号触发时同样更新修改时间，避免轮询再次加载
+				lastModTime = configModTime(Config())
+				if err := Reload(); err != nil {
+					log.Errorf("reload config %s error %v", Config().path, err)
+				}
+			}
+		}()
+	})
+}
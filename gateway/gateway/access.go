@@ -0,0 +1,176 @@
+package gateway
+
+// 客户端访问控制：Origin白名单、IP黑白名单、连接数限制
+// 经可信代理的连接通过X-Forwarded-For或PROXY协议获取客户端真实IP
+// 规则每次从当前配置读取，配置热更新后对新连接生效
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/guogeer/quasar/v2/config"
+	"github.com/guogeer/quasar/v2/log"
+)
+
+var (
+	errAccessDenied  = errors.New("access denied")
+	errTooManyConns  = errors.New("too many connections")
+	errTooManyIPConn = errors.New("too many connections from ip")
+)
+
+// 匹配IP或CIDR，无效的规则忽略
+func matchIP(rules []string, ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, rule := range rules {
+		if strings.Contains(rule, "/") {
+			prefix, err := netip.ParsePrefix(rule)
+			if err != nil {
+				log.Warnf("invalid cidr %s", rule)
+				continue
+			}
+			if prefix.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		addr, err := netip.ParseAddr(rule)
+		if err != nil {
+			log.Warnf("invalid ip %s", rule)
+			continue
+		}
+		if addr.Unmap() == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// 客户端地址的IP。地址为ip:port或ip
+func hostIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+func parseIP(addr string) (netip.Addr, bool) {
+	ip, err := netip.ParseAddr(hostIP(addr))
+	return ip, err == nil
+}
+
+func isTrustedProxy(conf config.AccessConfig, addr string) bool {
+	ip, ok := parseIP(addr)
+	return ok && matchIP(conf.TrustedProxies, ip)
+}
+
+// 检查IP黑白名单
+func checkIP(conf config.AccessConfig, addr string) error {
+	ip, ok := parseIP(addr)
+	if !ok {
+		return errAccessDenied
+	}
+	if matchIP(conf.Deny, ip) {
+		return errAccessDenied
+	}
+	if len(conf.Allow) > 0 && !matchIP(conf.Allow, ip) {
+		return errAccessDenied
+	}
+	return nil
+}
+
+// 客户端真实地址。经可信代理时，X-Forwarded-For从右向左取第一个不可信的IP
+func requestClientAddr(conf config.AccessConfig, r *http.Request) string {
+	addr := r.RemoteAddr
+	if !isTrustedProxy(conf, addr) {
+		return addr
+	}
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if _, ok := parseIP(hops[i]); !ok {
+			break
+		}
+		addr = hops[i]
+		if !isTrustedProxy(conf, addr) {
+			break
+		}
+	}
+	return addr
+}
+
+// 未配置时允许所有的Origin。非浏览器客户端不携带Origin
+func checkOrigin(conf config.AccessConfig, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(conf.Origins) == 0 || origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, rule := range conf.Origins {
+		rule = strings.ToLower(rule)
+		switch {
+		case rule == "*" || rule == strings.ToLower(origin) || rule == host:
+			return true
+		case strings.HasPrefix(rule, "*.") && strings.HasSuffix(host, rule[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+// 网关的连接数
+type connLimiter struct {
+	mu    sync.Mutex
+	total int
+	ips   map[string]int
+}
+
+func newConnLimiter() *connLimiter {
+	return &connLimiter{ips: map[string]int{}}
+}
+
+// 占用连接数，成功后需调用release
+func (l *connLimiter) acquire(conf config.AccessConfig, ip string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if conf.MaxConns > 0 && l.total >= conf.MaxConns {
+		return errTooManyConns
+	}
+	if conf.MaxConnsPerIP > 0 && l.ips[ip] >= conf.MaxConnsPerIP {
+		return errTooManyIPConn
+	}
+	l.total++
+	l.ips[ip]++
+	return nil
+}
+
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	if l.ips[ip]--; l.ips[ip] <= 0 {
+		delete(l.ips, ip)
+	}
+}
+
+// 检查IP并占用连接数
+func (g *Gateway) admit(conf config.AccessConfig, addr string) error {
+	if err := checkIP(conf, addr); err != nil {
+		return err
+	}
+	return g.conns.acquire(conf, hostIP(addr))
+}
@@ -0,0 +1,143 @@
+package gateway
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"github.com/guogeer/quasar/v2/config"
+)
+
+func TestMatchIP(t *testing.T) {
+	rules := []string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32", "bad", "1.1.1.1/40"}
+	tests := []struct {
+		ip    string
+		match bool
+	}{
+		{"10.1.2.3", true},
+		{"::ffff:10.1.2.3", true}, // IPv4映射的IPv6地址
+		{"11.0.0.1", false},
+		{"192.168.1.1", true},
+		{"192.168.1.2", false},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+		{"1.1.1.1", false}, // 无效的规则忽略
+	}
+	for _, tt := range tests {
+		if match := matchIP(rules, netip.MustParseAddr(tt.ip)); match != tt.match {
+			t.Errorf("match ip %s %v", tt.ip, match)
+		}
+	}
+}
+
+func TestCheckIP(t *testing.T) {
+	conf := config.AccessConfig{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.0.0.1"}}
+	tests := []struct {
+		addr string
+		err  error
+	}{
+		{"10.0.0.2:80", nil},
+		{"10.0.0.2", nil},
+		{"10.0.0.1:80", errAccessDenied},
+		{"11.0.0.1:80", errAccessDenied},
+		{"unknown", errAccessDenied},
+	}
+	for _, tt := range tests {
+		if err := checkIP(conf, tt.addr); err != tt.err {
+			t.Errorf("check ip %s %v", tt.addr, err)
+		}
+	}
+	if err := checkIP(config.AccessConfig{}, "11.0.0.1:80"); err != nil {
+		t.Errorf("check ip without rules %v", err)
+	}
+}
+
+func TestRequestClientAddr(t *testing.T) {
+	conf := config.AccessConfig{TrustedProxies: []string{"10.0.0.0/8"}}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		addr       string
+	}{
+		{"direct", "1.1.1.1:80", []string{"2.2.2.2"}, "1.1.1.1:80"},
+		{"no header", "10.0.0.1:80", nil, "10.0.0.1:80"},
+		{"one proxy", "10.0.0.1:80", []string{"2.2.2.2"}, "2.2.2.2"},
+		{"spoofed", "10.0.0.1:80", []string{"3.3.3.3, 2.2.2.2"}, "2.2.2.2"},
+		{"proxy chain", "10.0.0.1:80", []string{"3.3.3.3, 2.2.2.2, 10.0.0.2"}, "2.2.2.2"},
+		{"multiple headers", "10.0.0.1:80", []string{"3.3.3.3", "2.2.2.2 , 10.0.0.2"}, "2.2.2.2"},
+		{"all trusted", "10.0.0.1:80", []string{"10.0.0.3,10.0.0.2"}, "10.0.0.3"},
+		{"invalid hop", "10.0.0.1:80", []string{"2.2.2.2,unknown"}, "10.0.0.1:80"},
+		{"empty hop", "10.0.0.1:80", []string{"2.2.2.2,,"}, "2.2.2.2"},
+	}
+	for _, tt := range tests {
+		r := &http.Request{RemoteAddr: tt.remoteAddr, Header: http.Header{}}
+		for _, v := range tt.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if addr := requestClientAddr(conf, r); addr != tt.addr {
+			t.Errorf("%s client addr %s", tt.name, addr)
+		}
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	conf := config.AccessConfig{Origins: []string{"https://example.com", "*.example.org", "game.com"}}
+	tests := []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true}, // 非浏览器客户端
+		{"https://example.com", true},
+		{"HTTPS://Example.com", true},
+		{"http://example.com", false},
+		{"https://a.example.org", true},
+		{"https://example.org", false},
+		{"https://evilexample.org", false},
+		{"http://game.com:8080", true},
+		{"https://game.com.evil.com", false},
+		{"://bad", false},
+	}
+	for _, tt := range tests {
+		r := &http.Request{Header: http.Header{}}
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if ok := checkOrigin(conf, r); ok != tt.ok {
+			t.Errorf("check origin %q %v", tt.origin, ok)
+		}
+	}
+
+	r := &http.Request{Header: http.Header{"Origin": {"https://any.com"}}}
+	if !checkOrigin(config.AccessConfig{}, r) {
+		t.Error("check origin without rules")
+	}
+}
+
+func TestConnLimiter(t *testing.T) {
+	conf := config.AccessConfig{MaxConns: 3, MaxConnsPerIP: 2}
+	l := newConnLimiter()
+	steps := []struct {
+		ip  string
+		err error
+	}{
+		{"1.1.1.1", nil},
+		{"1.1.1.1", nil},
+		{"1.1.1.1", errTooManyIPConn},
+		{"2.2.2.2", nil},
+		{"3.3.3.3", errTooManyConns},
+	}
+	for i, step := range steps {
+		if err := l.acquire(conf, step.ip); err != step.err {
+			t.Errorf("step %d acquire %s %v", i, step.ip, err)
+		}
+	}
+	l.release("1.1.1.1")
+	if err := l.acquire(conf, "1.1.1.1"); err != nil {
+		t.Errorf("acquire after release %v", err)
+	}
+	l.release("2.2.2.2")
+	if _, ok := l.ips["2.2.2.2"]; ok || l.total != 2 {
+		t.Errorf("release conn total %d ips %v", l.total, l.ips)
+	}
+}
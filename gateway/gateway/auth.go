@@ -0,0 +1,199 @@
+package gateway
+
+// 客户端认证
+// 1、建立连接时通过参数?token=或请求头Authorization: Bearer携带凭证
+// 2、建立连接后第一个消息auth携带凭证：{"token":"..."}
+// 认证结果通过消息auth回复，认证后的用户ID随消息转发到业务服
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/config"
+)
+
+const authTimeout = 10 * time.Second // 建立连接后未认证的超时时间
+
+var (
+	errInvalidToken = errors.New("invalid token")
+	errTokenExpire  = errors.New("token expire")
+)
+
+type AuthRequest struct {
+	Token      string
+	ClientAddr string
+}
+
+// 认证客户端，返回用户ID
+type Authenticator interface {
+	Authenticate(req *AuthRequest) (string, error)
+}
+
+type AuthFunc func(req *AuthRequest) (string, error)
+
+func (f AuthFunc) Authenticate(req *AuthRequest) (string, error) {
+	return f(req)
+}
+
+type authArgs struct {
+	Token string `json:"token,omitempty"`
+}
+
+// 根据配置创建认证方式，不认证时返回nil
+func newAuthenticator(conf config.AuthConfig, set *cmd.CmdSet) (Authenticator, error) {
+	switch conf.Type {
+	case "":
+		return nil, nil
+	case "hmac":
+		return &hmacAuth{key: []byte(conf.Key)}, nil
+	case "jwt":
+		return &jwtAuth{key: []byte(conf.Key)}, nil
+	case "service":
+		serverName, msgId, ok := strings.Cut(conf.Service, ".")
+		if !ok {
+			return nil, fmt.Errorf("invalid auth service %q", conf.Service)
+		}
+		return &serviceAuth{set: set, serverName: serverName, msgId: msgId}, nil
+	}
+	return nil, fmt.Errorf("unsupported auth type %q", conf.Type)
+}
+
+func requestToken(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	if s, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return s
+	}
+	return ""
+}
+
+func hmacSum(key []byte, s string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(s))
+	return mac.Sum(nil)
+}
+
+// 凭证格式：{userId}.{过期时间戳}.{hex(hmac-sha256(key, "{userId}.{过期时间戳}"))}
+type hmacAuth struct {
+	key []byte
+}
+
+func (auth *hmacAuth) Authenticate(req *AuthRequest) (string, error) {
+	n := strings.LastIndexByte(req.Token, '.')
+	if n < 0 {
+		return "", errInvalidToken
+	}
+	payload, sign := req.Token[:n], req.Token[n+1:]
+	sum, err := hex.DecodeString(sign)
+	if err != nil || !hmac.Equal(sum, hmacSum(auth.key, payload)) {
+		return "", errInvalidToken
+	}
+
+	n = strings.LastIndexByte(payload, '.')
+	if n < 0 {
+		return "", errInvalidToken
+	}
+	userId := payload[:n]
+	expire, err := strconv.ParseInt(payload[n+1:], 10, 64)
+	if err != nil || userId == "" {
+		return "", errInvalidToken
+	}
+	if expire < time.Now().Unix() {
+		return "", errTokenExpire
+	}
+	return userId, nil
+}
+
+// 仅支持HS256签名，用户ID取sub
+type jwtAuth struct {
+	key []byte
+}
+
+type jwtClaims struct {
+	Sub string `json:"sub,omitempty"`
+	Exp int64  `json:"exp,omitempty"`
+	Nbf int64  `json:"nbf,omitempty"`
+}
+
+func (auth *jwtAuth) Authenticate(req *AuthRequest) (string, error) {
+	parts := strings.Split(req.Token, ".")
+	if len(parts) != 3 {
+		return "", errInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg,omitempty"`
+	}
+	headerBuf, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(headerBuf, &header) != nil || header.Alg != "HS256" {
+		return "", errInvalidToken
+	}
+	sign, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sign, hmacSum(auth.key, parts[0]+"."+parts[1])) {
+		return "", errInvalidToken
+	}
+
+	claims := &jwtClaims{}
+	claimsBuf, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(claimsBuf, claims) != nil || claims.Sub == "" {
+		return "", errInvalidToken
+	}
+	now := time.Now().Unix()
+	if (claims.Exp > 0 && claims.Exp < now) || claims.Nbf > now {
+		return "", errTokenExpire
+	}
+	return claims.Sub, nil
+}
+
+// 请求登录服务认证，回复格式同cmd.Reply：{"data":{"userId":"..."}}
+type serviceAuth struct {
+	set        *cmd.CmdSet
+	serverName string
+	msgId      string
+}
+
+func (auth *serviceAuth) Authenticate(req *AuthRequest) (string, error) {
+	buf, err := auth.set.Request(auth.serverName, auth.msgId, cmd.M{"token": req.Token, "clientAddr": req.ClientAddr})
+	if err != nil {
+		return "", err
+	}
+
+	var reply struct {
+		cmd.Reply
+		Data struct {
+			UserId string `json:"userId,omitempty"`
+		} `json:"data,omitempty"`
+	}
+	if err := json.Unmarshal(buf, &reply); err != nil {
+		return "", err
+	}
+	if reply.Code != "" {
+		return "", cmd.NewError(reply.Code, reply.Msg)
+	}
+	if reply.Data.UserId == "" {
+		return "", errInvalidToken
+	}
+	return reply.Data.UserId, nil
+}
+
+func authReply(userId string, err error) *cmd.Reply {
+	if err != nil {
+		var e *cmd.Error
+		if errors.As(err, &e) {
+			return &cmd.Reply{Code: e.Code, Msg: e.Msg}
+		}
+		return &cmd.Reply{Code: cmd.CodeUnauthorized, Msg: err.Error()}
+	}
+	return &cmd.Reply{Data: cmd.M{"userId": userId}}
+}
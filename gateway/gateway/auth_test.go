@@ -0,0 +1,20 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/guogeer/quasar/v2/cmd"
+)
+
+func TestAuthReply(t *testing.T) {
+	if reply := authReply("", errInvalidToken); reply.Code != cmd.CodeUnauthorized || reply.Msg != errInvalidToken.Error() {
+		t.Errorf("auth fail reply %+v", reply)
+	}
+	// 认证方式返回的错误码保留
+	if reply := authReply("", cmd.NewError("banned", "user is banned")); reply.Code != "banned" {
+		t.Errorf("auth error reply %+v", reply)
+	}
+	if reply := authReply("1001", nil); reply.Code != "" || reply.Data.(cmd.M)["userId"] != "1001" {
+		t.Errorf("auth reply %+v", reply)
+	}
+}
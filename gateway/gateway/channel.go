@@ -0,0 +1,102 @@
+package gateway
+
+// 会话订阅的频道。首个会话加入或最后的会话退出时通知路由
+
+import (
+	"github.com/guogeer/quasar/v2/cmd"
+)
+
+func (g *Gateway) joinChannel(ssid, channel string) {
+	if channel == "" {
+		return
+	}
+	g.channelMu.Lock()
+	defer g.channelMu.Unlock()
+	if _, ok := g.channels[channel]; !ok {
+		g.channels[channel] = map[string]bool{}
+		g.set.Route("router", "c2s_subscribe", cmd.M{"channel": channel})
+	}
+	g.channels[channel][ssid] = true
+	if _, ok := g.sessionChannels[ssid]; !ok {
+		g.sessionChannels[ssid] = map[string]bool{}
+	}
+	g.sessionChannels[ssid][channel] = true
+}
+
+func (g *Gateway) leaveChannelLocked(ssid, channel string) {
+	if ssids, ok := g.channels[channel]; ok {
+		delete(ssids, ssid)
+		if len(ssids) == 0 {
+			delete(g.channels, channel)
+			g.set.Route("router", "c2s_unsubscribe", cmd.M{"channel": channel})
+		}
+	}
+	if joined, ok := g.sessionChannels[ssid]; ok {
+		delete(joined, channel)
+		if len(joined) == 0 {
+			delete(g.sessionChannels, ssid)
+		}
+	}
+}
+
+func (g *Gateway) leaveChannel(ssid, channel string) {
+	g.channelMu.Lock()
+	defer g.channelMu.Unlock()
+	g.leaveChannelLocked(ssid, channel)
+}
+
+// 会话退出所有的频道，返回退出的频道
+func (g *Gateway) leaveAllChannels(ssid string) []string {
+	g.channelMu.Lock()
+	defer g.channelMu.Unlock()
+
+	var leaves []string
+	for channel := range g.sessionChannels[ssid] {
+		leaves = append(leaves, channel)
+	}
+	for _, channel := range leaves {
+		g.leaveChannelLocked(ssid, channel)
+	}
+	return leaves
+}
+
+func (g *Gateway) funcJoinChannel(ctx *cmd.Context, data any) {
+	args := data.(*gatewayArgs)
+	if g.set.GetSession(ctx.Ssid) != nil {
+		g.joinChannel(ctx.Ssid, args.Channel)
+	}
+}
+
+func (g *Gateway) funcLeaveChannel(ctx *cmd.Context, data any) {
+	args := data.(*gatewayArgs)
+	g.leaveChannel(ctx.Ssid, args.Channel)
+}
+
+func (g *Gateway) funcPublish(ctx *cmd.Context, data any) {
+	args := data.(*gatewayArgs)
+
+	g.channelMu.Lock()
+	var ssids []string
+	for ssid := range g.channels[args.Channel] {
+		ssids = append(ssids, ssid)
+	}
+	g.channelMu.Unlock()
+
+	for _, ssid := range ssids {
+		if ss := g.set.GetSession(ssid); ss != nil {
+			ss.Out.WriteJSON(args.Id, args.Data)
+		}
+	}
+}
+
+// 网关注册后路由查询订阅的频道
+func (g *Gateway) s2cQueryChannels(ctx *cmd.Context, data any) {
+	g.channelMu.Lock()
+	subscribed := []string{}
+	for channel := range g.channels {
+		subscribed = append(subscribed, channel)
+	}
+	g.channelMu.Unlock()
+
+	g.set.Route("router", "c2s_syncChannels", cmd.M{"channels": subscribed})
+}
@@ -0,0 +1,164 @@
+package gateway
+
+// 客户端连接。WebSocket、TCP、KCP共用会话、认证、限流及转发逻辑
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/log"
+	"github.com/guogeer/quasar/v2/utils"
+)
+
+type clientConn interface {
+	cmd.Conn
+	// 读取并解析客户端的消息，连接断开或数据无效时返回错误
+	ReadPackage() (*cmd.Package, error)
+	SetReadDeadline(t time.Time) error
+	// 写入编码后的消息，id为消息ID
+	writeMsg(id string, data []byte) error
+}
+
+// 处理客户端的消息直到连接断开。userId为建立连接时认证的用户，resumeToken为断线重连的凭证
+func (g *Gateway) serveClient(c clientConn, auth Authenticator, userId, resumeToken string) {
+	// 断线重连恢复会话
+	var sc *sessionConn
+	var isResume bool
+	if resumeToken != "" {
+		var err error
+		if sc, err = g.resumeSession(c, resumeToken); err != nil {
+			log.Debugf("client %s resume session error %v", c.RemoteAddr(), err)
+			c.WriteJSON("resume", resumeReply("", 0, err))
+			sc = nil
+		}
+		isResume = sc != nil
+	}
+	if sc == nil {
+		sc = g.newSessionConn(utils.GUID(), userId)
+		sc.out = c
+		// 用户ID仅保存在sessionConn中，转发时由Context携带
+		g.set.AddSession(&cmd.Session{Id: sc.ssid, Out: sc})
+		g.bindUser(sc.ssid, userId)
+	}
+	defer func() {
+		c.Close()
+		// 保留会话等待重连
+		if sc.detach(c) {
+			g.set.RemoveSession(sc.ssid)
+			ctx := &cmd.Context{Ssid: sc.ssid, Out: sc, UserId: sc.UserId()}
+			g.set.Handle(ctx, "func_close", nil)
+		}
+	}()
+	if g.opts.OnConnect != nil {
+		info := &ClientInfo{Ssid: sc.ssid, UserId: sc.UserId(), RemoteAddr: c.RemoteAddr(), IsResume: isResume}
+		if err := g.opts.OnConnect(info); err != nil {
+			log.Debugf("client %s connect error %v", c.RemoteAddr(), err)
+			return
+		}
+	}
+	if gracePeriod, _ := resumeConfig(); gracePeriod > 0 {
+		c.WriteJSON("resumeToken", cmd.M{"token": sc.ResumeToken(), "gracePeriod": int(gracePeriod.Seconds())})
+	}
+
+	c.SetReadDeadline(time.Now().Add(pongWait))
+	if auth != nil && sc.UserId() == "" {
+		c.SetReadDeadline(time.Now().Add(authTimeout))
+	}
+
+	var oldServerName, oldMatchServerId string
+	if v, ok := g.sessionLocations.Load(sc.ssid); ok {
+		loc := v.(*sessionLocation)
+		oldServerName, oldMatchServerId = loc.ServerName, loc.MatchServerId
+	}
+
+	limiter := newSessionLimiter(g.ipLimiters)
+	remoteAddr := c.RemoteAddr()
+	remoteIP := hostIP(remoteAddr)
+	matchMsg, _ := regexp.Compile("^[A-Za-z0-9]+$")
+	for {
+		pkg, err := c.ReadPackage()
+		if err != nil {
+			return
+		}
+
+		// 网络限流
+		if action, scope := limiter.check(remoteIP, pkg.Id); action != "" {
+			log.Warnf("client %s send %s exceed %s rate limit, %s", remoteAddr, pkg.Id, scope, action)
+			if action != rateLimitWarn {
+				c.WriteJSON("rateLimit", cmd.M{"msgId": pkg.Id, "scope": scope, "action": action})
+			}
+			if action == rateLimitDisconnect {
+				return // 消息发送过快，直接关闭链接
+			}
+			if action == rateLimitDrop {
+				continue
+			}
+		}
+
+		// 第一个消息认证
+		if auth != nil && sc.UserId() == "" {
+			args := &authArgs{}
+			if pkg.Id != "auth" || json.Unmarshal(pkg.Data, args) != nil {
+				c.WriteJSON("auth", authReply("", errInvalidToken))
+				return
+			}
+			userId, err := auth.Authenticate(&AuthRequest{Token: args.Token, ClientAddr: remoteAddr})
+			c.WriteJSON("auth", authReply(userId, err))
+			if err != nil {
+				log.Debugf("client %s authenticate error %v", remoteAddr, err)
+				return
+			}
+			sc.setUserId(userId)
+			g.bindUser(sc.ssid, userId)
+			c.SetReadDeadline(time.Now().Add(pongWait))
+			continue
+		}
+
+		// 网关转发的消息ID仅允许包含字母、数字
+		var serverName, matchServerId string
+		if servers := strings.SplitN(pkg.Id, ".", 2); len(servers) > 1 {
+			serverName = servers[0]
+			if !matchMsg.MatchString(servers[1]) {
+				log.Warnf("invalid message id %s", pkg.Id)
+				continue
+			}
+			matchServerId = oldMatchServerId
+			// 请求的新服务
+			if serverName != oldServerName {
+				matchServerId = g.matchBestServer(sc.ssid, sc.canaryKey(), sc.UserId(), serverName)
+				if matchServerId != oldMatchServerId && matchServerId != "" {
+					oldServerName, oldMatchServerId = serverName, matchServerId
+				}
+			}
+			// log.Debugf("serverName:%s matchServer:%s oldServer:%s oldMatchServer:%s", serverName, matchServer, oldServer, oldMatchServer)
+			// 服务有效
+			// 无效的服务
+			if matchServerId == "" || !g.isServerAlive(matchServerId) {
+				c.WriteJSON("serverClose", cmd.M{"serverName": servers[0], "cause": "not alive"})
+				continue
+			}
+		}
+
+		ctx := &cmd.Context{
+			Out:         sc,
+			Ssid:        sc.ssid,
+			ClientAddr:  c.RemoteAddr(),
+			MatchServer: matchServerId,
+			ServerName:  serverName,
+			UserId:      sc.UserId(),
+			IsClient:    true,
+		}
+		if g.opts.OnRoute != nil {
+			if err := g.opts.OnRoute(ctx, pkg.Id); err != nil {
+				log.Debugf("client %s route %s error %v", remoteAddr, pkg.Id, err)
+				continue
+			}
+		}
+		if err := g.set.Handle(ctx, pkg.Id, pkg.Data); err != nil {
+			log.Warnf("handle client %s %v", remoteAddr, err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package gateway
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/guogeer/quasar/v2/cmd"
+)
+
+// 模拟的客户端连接，依次返回消息，读完后断开
+type testClientConn struct {
+	testConn
+	pkgs  []*cmd.Package
+	onEOF func()
+}
+
+func (c *testClientConn) ReadPackage() (*cmd.Package, error) {
+	if len(c.pkgs) == 0 {
+		if c.onEOF != nil {
+			c.onEOF()
+		}
+		return nil, io.EOF
+	}
+	pkg := c.pkgs[0]
+	c.pkgs = c.pkgs[1:]
+	return pkg, nil
+}
+
+func (c *testClientConn) SetReadDeadline(t time.Time) error { return nil }
+
+func TestClientPrivateMessage(t *testing.T) {
+	g := New(Options{Id: "gw_1"})
+	var pushes int
+	g.set.Bind("func_test", func(ctx *cmd.Context, data any) { pushes++ }, nil, cmd.WithPrivate())
+
+	// 客户端直接发送内部消息，不可加入频道或推送
+	c := &testClientConn{pkgs: []*cmd.Package{
+		{Id: "func_joinChannel", Data: []byte(`{"channel":"news"}`)},
+		{Id: "func_push", Data: []byte(`{"ssids":["ss1"],"id":"s2c_evil"}`)},
+		{Id: "func_test"},
+	}}
+	var channels int
+	c.onEOF = func() {
+		g.channelMu.Lock()
+		channels = len(g.channels)
+		g.channelMu.Unlock()
+	}
+	g.serveClient(c, nil, "", "")
+	if channels != 0 || pushes != 0 {
+		t.Errorf("client handle private messages, channels %d pushes %d", channels, pushes)
+	}
+}
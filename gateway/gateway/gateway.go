@@ -0,0 +1,263 @@
+package gateway
+
+// 网关。转发客户端消息到业务服，支持WebSocket、TCP、KCP客户端
+// 可嵌入其他进程，通过Options的钩子扩展连接、转发、关闭的处理
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/config"
+	"github.com/guogeer/quasar/v2/log"
+	"github.com/guogeer/quasar/v2/utils"
+)
+
+const defaultId = "ws_gateway"
+
+type Options struct {
+	Id        string // 网关ID。默认ws_gateway
+	Addr      string // 注册到路由的客户端连接地址
+	MinWeight int
+	MaxWeight int
+	Labels    map[string]string // 注册到路由的标签，如version
+
+	CmdSet        *cmd.CmdSet   // 处理路由、业务服的消息。为空时新建
+	Authenticator Authenticator // 客户端认证。为空时根据配置auth创建
+
+	OnConnect func(info *ClientInfo) error               // 建立会话后，返回错误时断开连接
+	OnRoute   func(ctx *cmd.Context, msgId string) error // 处理客户端消息前，返回错误时丢弃消息
+	OnClose   func(ssid, userId string)                  // 会话关闭后
+}
+
+type ClientInfo struct {
+	Ssid       string
+	UserId     string
+	RemoteAddr string
+	IsResume   bool // 断线重连恢复的会话
+}
+
+type Gateway struct {
+	opts Options
+	set  *cmd.CmdSet
+
+	sessionLocations sync.Map // 连接会话的位置。[ssid:*sessionLocation]
+
+	serverStates  map[string]serverState // 服务负载。[serverId:serverState]
+	routeRules    []cmd.RouteRule        // 灰度规则
+	serverStateMu sync.RWMutex
+
+	channels        map[string]map[string]bool // 频道的会话。[channel:[ssid]]
+	sessionChannels map[string]map[string]bool // 会话加入的频道。[ssid:[channel]]
+	channelMu       sync.Mutex
+
+	resumeWaits   sync.Map // 等待其他网关迁移的会话。[ssid:chan *sessionConn]
+	ipLimiters    *ipLimiter
+	conns         *connLimiter
+	wsConns       sync.Map // WebSocket客户端连接。[*WsConn:true]
+	sendStats     *sendQueueStats
+	lastSendStats SendQueueStats // 上次打印的统计，仅在定时器中使用
+	startOnce     sync.Once
+}
+
+type serverState struct {
+	Id        string `json:"id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Weight    int    `json:"weight,omitempty"`
+	MaxWeight int    `json:"maxWeight,omitempty"`
+	MinWeight int    `json:"minWeight,omitempty"`
+	Unhealthy bool   `json:"unhealthy,omitempty"` // 不健康的服务不再匹配新的会话
+	Draining  bool   `json:"draining,omitempty"`  // 下线中的服务不再匹配新的会话
+
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type sessionLocation struct {
+	MatchServerId string `json:"matchServerId,omitempty"` // 服务的ID
+	ServerName    string `json:"serverName,omitempty"`    // 客户端请求的协议头
+}
+
+func New(opts Options) *Gateway {
+	if opts.Id == "" {
+		opts.Id = defaultId
+	}
+	set := opts.CmdSet
+	if set == nil {
+		set = cmd.NewCmdSet()
+	}
+	g := &Gateway{
+		opts:            opts,
+		set:             set,
+		serverStates:    map[string]serverState{},
+		channels:        map[string]map[string]bool{},
+		sessionChannels: map[string]map[string]bool{},
+		ipLimiters:      &ipLimiter{buckets: map[string]*ruleBucket{}},
+		conns:           newConnLimiter(),
+		sendStats:       &sendQueueStats{},
+	}
+	g.bind()
+	return g
+}
+
+func (g *Gateway) Id() string {
+	return g.opts.Id
+}
+
+// 网关处理消息的CmdSet，可绑定自定义的消息
+func (g *Gateway) CmdSet() *cmd.CmdSet {
+	return g.set
+}
+
+// 注册到路由并定时上报负载，需在主循环中执行utils.GetTimerSet().RunOnce()
+func (g *Gateway) Start() {
+	g.startOnce.Do(func() {
+		g.set.RegisterService(&cmd.ServiceConfig{
+			Id:        g.opts.Id,
+			Name:      "gateway",
+			Addr:      g.opts.Addr,
+			MinWeight: g.opts.MinWeight,
+			MaxWeight: g.opts.MaxWeight,
+			Labels:    g.opts.Labels,
+		})
+		utils.NewPeriodTimer(g.concurrent, time.Now(), 10*time.Second)
+	})
+}
+
+// WebSocket客户端连接
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.serveWs(w, r)
+}
+
+func (g *Gateway) authenticator() (Authenticator, error) {
+	if g.opts.Authenticator != nil {
+		return g.opts.Authenticator, nil
+	}
+	return newAuthenticator(config.Config().Auth, g.set)
+}
+
+// update current online
+func (g *Gateway) concurrent() {
+	counter := g.set.CountSession()
+	data := serverState{Weight: counter}
+	g.set.Route("router", "c2s_concurrent", data)
+
+	g.set.Route("router", "c2s_queryServerState", cmd.M{})
+	g.logSendQueueStats()
+}
+
+// 打印发送队列满或丢弃消息的统计
+func (g *Gateway) logSendQueueStats() {
+	stats := g.SendQueueStats()
+	last := g.lastSendStats
+	g.lastSendStats = stats
+	if stats.Saturated > 0 || stats.Dropped != last.Dropped || stats.Disconnected != last.Disconnected {
+		log.Warnf("send queue conns %d queued %d saturated %d fill %v dropped %d coalesced %d disconnected %d",
+			stats.Conns, stats.Queued, stats.Saturated, stats.FillBuckets,
+			stats.Dropped-last.Dropped, stats.Coalesced-last.Coalesced, stats.Disconnected-last.Disconnected)
+	}
+}
+
+func (g *Gateway) isServerAlive(serverId string) bool {
+	g.serverStateMu.RLock()
+	defer g.serverStateMu.RUnlock()
+	_, ok := g.serverStates[serverId]
+	return ok
+}
+
+// 网关从路由同步的服务ID
+func (g *Gateway) ServerIds() []string {
+	g.serverStateMu.RLock()
+	defer g.serverStateMu.RUnlock()
+	ids := make([]string, 0, len(g.serverStates))
+	for id := range g.serverStates {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// 按灰度规则筛选服务。会话被规则选中时匹配灰度服务，否则匹配非灰度服务
+// 无满足的服务时不筛选。key为会话固定的选择键
+func (g *Gateway) filterCanaryServers(key, userId, name string, matchServers map[string]bool) map[string]bool {
+	var canaryServers map[string]bool
+	stableServers := map[string]bool{}
+	for serverId := range matchServers {
+		stableServers[serverId] = true
+	}
+	for _, rule := range g.routeRules {
+		if rule.Name != name {
+			continue
+		}
+		ruleServers := map[string]bool{}
+		for serverId := range matchServers {
+			if rule.MatchLabels(g.serverStates[serverId].Labels) {
+				ruleServers[serverId] = true
+				delete(stableServers, serverId)
+			}
+		}
+		if canaryServers == nil && rule.Select(key, userId) {
+			canaryServers = ruleServers
+		}
+	}
+	if len(canaryServers) > 0 {
+		return canaryServers
+	}
+	if len(stableServers) > 0 {
+		return stableServers
+	}
+	return matchServers
+}
+
+// 匹配最佳的服务
+// 匹配规则：
+// 1、serverId == name时直接选中
+// 2、按灰度规则筛选服务，key为会话固定的选择键
+// 3、优先匹配最小serverId且人数小于MinOnline
+// 4、匹配Weight最小
+func (g *Gateway) matchBestServer(ssid, key, userId, name string) string {
+	g.serverStateMu.RLock()
+	defer g.serverStateMu.RUnlock()
+
+	serverStates := g.serverStates
+	state, ok := serverStates[name]
+	if ok {
+		return state.Id
+	}
+
+	matchServers := map[string]bool{}
+	for _, server := range serverStates {
+		if !server.Unhealthy && !server.Draining && slices.Contains(strings.Split(server.Name, ","), name) {
+			matchServers[server.Id] = true
+		}
+	}
+	matchServers = g.filterCanaryServers(key, userId, name, matchServers)
+
+	if v, ok := g.sessionLocations.Load(ssid); ok {
+		loc := v.(*sessionLocation)
+		if matchServers[loc.MatchServerId] {
+			return loc.MatchServerId
+		}
+	}
+
+	var matchServerId string
+	for serverId := range matchServers {
+		state := serverStates[serverId]
+		if state.Weight < state.MinWeight && matchServerId < state.Id {
+			matchServerId = serverId
+		}
+	}
+	if matchServerId != "" {
+		return matchServerId
+	}
+	for server := range matchServers {
+		state := serverStates[server]
+		if (state.MaxWeight == 0 || state.Weight < state.MaxWeight) &&
+			(matchServerId == "" || state.Weight < serverStates[matchServerId].Weight) {
+			matchServerId = server
+		}
+	}
+	return matchServerId
+}
@@ -0,0 +1,128 @@
+package gateway
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/guogeer/quasar/v2/cmd"
+)
+
+func newCanaryGateway(percent int) *Gateway {
+	g := New(Options{Id: "gw_1"})
+	g.serverStates = map[string]serverState{
+		"hall_1": {Id: "hall_1", Name: "hall", Weight: 5},
+		"hall_2": {Id: "hall_2", Name: "hall", Weight: 1, Labels: map[string]string{"version": "2"}},
+		"hall_3": {Id: "hall_3", Name: "hall", Weight: 0, Unhealthy: true},
+		"hall_4": {Id: "hall_4", Name: "hall,login", Weight: 0, Draining: true},
+		"game_1": {Id: "game_1", Name: "game", Weight: 1},
+	}
+	g.routeRules = []cmd.RouteRule{{Name: "hall", Labels: map[string]string{"version": "2"}, Percent: percent, UserIds: []string{"1001"}}}
+	return g
+}
+
+func serverIds(servers map[string]bool) string {
+	return strings.Join(slices.Sorted(maps.Keys(servers)), ",")
+}
+
+func TestFilterCanaryServers(t *testing.T) {
+	all := map[string]bool{"hall_1": true, "hall_2": true}
+	tests := []struct {
+		name    string
+		percent int
+		key     string
+		userId  string
+		servers map[string]bool
+		expect  string
+	}{
+		{"stable", 0, "ss1", "", all, "hall_1"},
+		{"user", 0, "ss1", "1001", all, "hall_2"},
+		{"percent", 100, "ss1", "", all, "hall_2"},
+		{"no canary server", 100, "ss1", "", map[string]bool{"hall_1": true}, "hall_1"},
+		{"no stable server", 0, "ss1", "", map[string]bool{"hall_2": true}, "hall_2"},
+	}
+	for _, tt := range tests {
+		g := newCanaryGateway(tt.percent)
+		if servers := serverIds(g.filterCanaryServers(tt.key, tt.userId, "hall", tt.servers)); servers != tt.expect {
+			t.Errorf("%s filter servers %s", tt.name, servers)
+		}
+	}
+
+	// 其他服务的规则不筛选
+	g := newCanaryGateway(100)
+	if servers := serverIds(g.filterCanaryServers("ss1", "", "game", all)); servers != "hall_1,hall_2" {
+		t.Errorf("filter other servers %s", servers)
+	}
+}
+
+func TestMatchBestServer(t *testing.T) {
+	tests := []struct {
+		name    string
+		percent int
+		key     string
+		userId  string
+		server  string
+		expect  string
+	}{
+		{"server id", 0, "ss1", "", "hall_3", "hall_3"},
+		{"stable", 0, "ss1", "", "hall", "hall_1"},
+		{"user", 0, "ss1", "1001", "hall", "hall_2"},
+		{"percent", 100, "ss1", "", "hall", "hall_2"},
+		{"unavailable", 0, "ss1", "", "login", ""},
+		{"unknown", 0, "ss1", "", "shop", ""},
+	}
+	for _, tt := range tests {
+		g := newCanaryGateway(tt.percent)
+		if serverId := g.matchBestServer("ss1", tt.key, tt.userId, tt.server); serverId != tt.expect {
+			t.Errorf("%s match server %s", tt.name, serverId)
+		}
+	}
+
+	// 会话已在筛选后的服务中时保持不变
+	g := newCanaryGateway(0)
+	g.serverStates["hall_5"] = serverState{Id: "hall_5", Name: "hall", Weight: 9}
+	g.sessionLocations.Store("ss1", &sessionLocation{ServerName: "hall", MatchServerId: "hall_5"})
+	if serverId := g.matchBestServer("ss1", "ss1", "", "hall"); serverId != "hall_5" {
+		t.Errorf("match session location %s", serverId)
+	}
+}
+
+func TestCanaryKey(t *testing.T) {
+	g := newCanaryGateway(50)
+	rule := g.routeRules[0]
+	// 会话ID选中而用户ID未选中
+	var ssid, userId string
+	for i := 0; ssid == "" || userId == ""; i++ {
+		if s := "ss" + strconv.Itoa(i); ssid == "" && rule.Select(s, "") {
+			ssid = s
+		}
+		if s := strconv.Itoa(2000 + i); userId == "" && !rule.Select(s, "") {
+			userId = s
+		}
+	}
+
+	// 认证后仍使用认证前的选择键，不切换服务
+	sc := g.newSessionConn(ssid, "")
+	if serverId := g.matchBestServer(ssid, sc.canaryKey(), sc.UserId(), "hall"); serverId != "hall_2" {
+		t.Fatalf("match server before auth %s", serverId)
+	}
+	sc.setUserId(userId)
+	if serverId := g.matchBestServer(ssid, sc.canaryKey(), sc.UserId(), "hall"); serverId != "hall_2" {
+		t.Errorf("match server after auth %s", serverId)
+	}
+
+	// 已认证的会话使用用户ID
+	if key := g.newSessionConn("ss_a", userId).canaryKey(); key != userId {
+		t.Errorf("canary key %s", key)
+	}
+
+	// 迁移的会话保留选择键
+	handleResume(g, "", "func_resumeResult", &resumeArgs{Ssid: "ss_b", UserId: userId, SelectKey: ssid})
+	g.resumeWaits.Store("ss_c", make(chan *sessionConn, 1))
+	handleResume(g, "", "func_resumeResult", &resumeArgs{Ssid: "ss_c", UserId: userId, SelectKey: ssid})
+	if sc := g.getSessionConn("ss_c"); sc == nil || sc.canaryKey() != ssid {
+		t.Errorf("resume canary key %v", sc)
+	}
+}
@@ -0,0 +1,144 @@
+package gateway
+
+import (
+	"encoding/json"
+
+	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/log"
+)
+
+type gatewayArgs struct {
+	Id   string          `json:"id,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+
+	ServerId      string `json:"serverId,omitempty"`
+	ServerName    string `json:"serverName,omitempty"`
+	MatchServerId string `json:"matchServerId,omitempty"` // 匹配的ServerId
+
+	Name    string        `json:"name,omitempty"`
+	Servers []serverState `json:"servers,omitempty"`
+	Ssids   []string      `json:"ssids,omitempty"`
+	Channel string        `json:"channel,omitempty"`
+
+	Rules []cmd.RouteRule `json:"rules,omitempty"`
+}
+
+func (g *Gateway) bind() {
+	s := g.set
+	s.Bind("func_route", g.funcRoute, (*gatewayArgs)(nil), cmd.WithPrivate())
+	s.Bind("heartBeat", g.heartBeat, (*gatewayArgs)(nil), cmd.WithoutQueue())
+	s.Bind("func_broadcast", g.funcBroadcast, (*gatewayArgs)(nil), cmd.WithPrivate())
+	s.Bind("func_switchServer", g.funcSwitchServer, (*gatewayArgs)(nil), cmd.WithPrivate())
+	s.Bind("func_close", g.funcClose, (*gatewayArgs)(nil), cmd.WithPrivate())
+	s.Bind("serverClose", g.serverClose, (*gatewayArgs)(nil), cmd.WithPrivate())
+	s.Bind("s2c_queryServerState", g.s2cQueryServerState, (*gatewayArgs)(nil), cmd.WithPrivate())
+	s.Bind("s2c_register", g.s2cRegister, (*gatewayArgs)(nil), cmd.WithPrivate())
+	s.Bind("s2c_routeRules", g.s2cRouteRules, (*gatewayArgs)(nil), cmd.WithPrivate())
+
+	s.Bind("s2c_queryUsers", g.s2cQueryUsers, (*gatewayArgs)(nil), cmd.WithPrivate())
+	s.Bind("func_push", g.funcPush, (*gatewayArgs)(nil), cmd.WithPrivate())
+
+	s.Bind("func_joinChannel", g.funcJoinChannel, (*gatewayArgs)(nil), cmd.WithPrivate())
+	s.Bind("func_leaveChannel", g.funcLeaveChannel, (*gatewayArgs)(nil), cmd.WithPrivate())
+	s.Bind("func_publish", g.funcPublish, (*gatewayArgs)(nil), cmd.WithPrivate())
+	s.Bind("s2c_queryChannels", g.s2cQueryChannels, (*gatewayArgs)(nil), cmd.WithPrivate())
+
+	s.Bind("func_resume", g.funcResume, (*resumeArgs)(nil), cmd.WithPrivate())
+	s.Bind("func_resumeResult", g.funcResumeResult, (*resumeArgs)(nil), cmd.WithPrivate())
+	s.Bind("func_resumeRoute", g.funcResumeRoute, (*resumeArgs)(nil), cmd.WithPrivate())
+}
+
+func (g *Gateway) closeSession(ss *cmd.Session) {
+	log.Debugf("session close %s", ss.Id)
+	if v, ok := g.sessionLocations.Load(ss.Id); ok {
+		loc := v.(*sessionLocation)
+		ss.Route(loc.MatchServerId, "close", struct{}{})
+	}
+	g.sessionLocations.Delete(ss.Id)
+}
+
+func (g *Gateway) funcClose(ctx *cmd.Context, data any) {
+	log.Debugf("session close %s", ctx.Ssid)
+	g.unbindUser(ctx.Ssid, ctx.UserId)
+	g.leaveAllChannels(ctx.Ssid)
+	ss := g.set.NewSession(ctx.Ssid, ctx.Out)
+	ss.UserId = ctx.UserId
+	g.closeSession(ss)
+	if g.opts.OnClose != nil {
+		g.opts.OnClose(ctx.Ssid, ctx.UserId)
+	}
+}
+
+func (g *Gateway) funcSwitchServer(ctx *cmd.Context, data any) {
+	args := data.(*gatewayArgs)
+	log.Debugf("session ssid:%s switch request server:%s,match server:%s", ctx.Ssid, args.ServerName, args.MatchServerId)
+	loc := &sessionLocation{ServerName: args.ServerName, MatchServerId: args.MatchServerId}
+	g.sessionLocations.Store(ctx.Ssid, loc)
+
+	// 新连接未关联业务服时断线，会丢失close消息
+	if g.set.GetSession(ctx.Ssid) == nil {
+		g.closeSession(g.set.NewSession(ctx.Ssid, ctx.Out))
+	}
+}
+
+// 直接转发消息到客户端
+func (g *Gateway) funcRoute(ctx *cmd.Context, data any) {
+	args := data.(*gatewayArgs)
+	if ss := g.set.GetSession(ctx.Ssid); ss != nil {
+		ss.Out.WriteJSON(args.Id, args.Data)
+	}
+}
+
+func (g *Gateway) funcBroadcast(ctx *cmd.Context, data any) {
+	args := data.(*gatewayArgs)
+	for _, ss := range g.set.GetSessionList() {
+		// 已迁移的会话由新网关广播
+		if sc, ok := ss.Out.(*sessionConn); ok && sc.isMoved() {
+			continue
+		}
+		ss.Out.WriteJSON(args.Id, args.Data)
+	}
+}
+
+func (g *Gateway) s2cRegister(ctx *cmd.Context, data any) {
+	g.set.Route("router", "c2s_queryServerState", cmd.M{})
+}
+
+func (g *Gateway) serverClose(ctx *cmd.Context, data any) {
+	args := data.(*gatewayArgs)
+	// 2020-11-24 仅通知在当前服务的连接
+	for _, ss := range g.set.GetSessionList() {
+		if v, ok := g.sessionLocations.Load(ss.Id); ok {
+			loc := v.(*sessionLocation)
+			if loc.MatchServerId == args.ServerId {
+				ss.Out.WriteJSON("serverClose", cmd.M{"serverName": loc.ServerName, "cause": "server crash"})
+			}
+		}
+	}
+	g.set.Route("router", "c2s_queryServerState", cmd.M{})
+}
+
+func (g *Gateway) heartBeat(ctx *cmd.Context, data any) {
+	ctx.Out.WriteJSON("heartBeat", cmd.M{})
+}
+
+// 同步服务负载
+func (g *Gateway) s2cQueryServerState(ctx *cmd.Context, data any) {
+	args := data.(*gatewayArgs)
+
+	g.serverStateMu.Lock()
+	defer g.serverStateMu.Unlock()
+	g.serverStates = map[string]serverState{}
+	for _, state := range args.Servers {
+		g.serverStates[state.Id] = state
+	}
+}
+
+// 路由同步的灰度规则
+func (g *Gateway) s2cRouteRules(ctx *cmd.Context, data any) {
+	args := data.(*gatewayArgs)
+
+	g.serverStateMu.Lock()
+	defer g.serverStateMu.Unlock()
+	g.routeRules = args.Rules
+}
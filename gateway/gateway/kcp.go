@@ -0,0 +1,33 @@
+package gateway
+
+// KCP客户端连接
+
+import (
+	"net"
+
+	kcp "github.com/xtaci/kcp-go/v5"
+)
+
+type kcpListener struct {
+	*kcp.Listener
+}
+
+// 低延迟模式，流模式下兼容4字节协议头
+func (l kcpListener) Accept() (net.Conn, error) {
+	s, err := l.AcceptKCP()
+	if err != nil {
+		return nil, err
+	}
+	s.SetStreamMode(true)
+	s.SetNoDelay(1, 10, 2, 1)
+	s.SetWindowSize(256, 256)
+	return s, nil
+}
+
+func listenKCP(addr string) (net.Listener, error) {
+	l, err := kcp.ListenWithOptions(addr, nil, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return kcpListener{Listener: l}, nil
+}
@@ -0,0 +1,44 @@
+package gateway
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	kcp "github.com/xtaci/kcp-go/v5"
+)
+
+func TestListenKCP(t *testing.T) {
+	l, err := listenKCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	client, err := kcp.DialWithOptions(l.Addr().String(), nil, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetStreamMode(true)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	// 收到第一个数据包后建立连接
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("server read %q %v", buf, err)
+	}
+	c.Write([]byte("world"))
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "world" {
+		t.Fatalf("client read %q %v", buf, err)
+	}
+}
@@ -0,0 +1,127 @@
+package gateway
+
+// PROXY协议v1、v2，负载均衡转发TCP连接时在数据前附加客户端的地址
+// 参考：https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const proxyHeaderTimeout = 5 * time.Second
+
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var errInvalidProxyHeader = errors.New("invalid proxy protocol header")
+
+// 读取PROXY协议头后的连接，RemoteAddr为客户端的地址
+type proxyConn struct {
+	net.Conn
+	r          *bufio.Reader
+	remoteAddr net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// 读取PROXY协议头
+func readProxyHeader(rwc net.Conn) (net.Conn, error) {
+	c := &proxyConn{Conn: rwc, r: bufio.NewReader(rwc)}
+	rwc.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer rwc.SetReadDeadline(time.Time{})
+
+	sig, err := c.r.Peek(len(proxyV2Sig))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(sig, proxyV2Sig) {
+		c.remoteAddr, err = readProxyV2(c.r)
+	} else if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		c.remoteAddr, err = readProxyV1(c.r)
+	} else {
+		err = errInvalidProxyHeader
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// 格式：PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	s, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errInvalidProxyHeader
+	}
+	fields := strings.Split(s, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errInvalidProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil {
+		return nil, errInvalidProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// 12字节签名+版本命令+地址族+2字节地址长度+地址
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	if head[12]>>4 != 2 {
+		return nil, errInvalidProxyHeader
+	}
+	data := make([]byte, binary.BigEndian.Uint16(head[14:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	// LOCAL命令为代理自身的连接，如健康检查
+	if head[12]&0x0f == 0 {
+		return nil, nil
+	}
+	switch head[13] >> 4 {
+	case 1: // IPv4
+		if len(data) < 12 {
+			return nil, errInvalidProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(data[:4]), Port: int(binary.BigEndian.Uint16(data[8:]))}, nil
+	case 2: // IPv6
+		if len(data) < 36 {
+			return nil, errInvalidProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(data[:16]), Port: int(binary.BigEndian.Uint16(data[32:]))}, nil
+	}
+	return nil, nil
+}
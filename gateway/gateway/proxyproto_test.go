@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// v2头：签名+版本命令+地址族+地址长度+地址
+func proxyV2Header(cmd, family byte, addr []byte) []byte {
+	buf := append([]byte{}, proxyV2Sig...)
+	buf = append(buf, cmd, family, byte(len(addr)>>8), byte(len(addr)))
+	return append(buf, addr...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	ipv4 := []byte{10, 0, 0, 1, 10, 0, 0, 2, 0x03, 0xe8, 0x01, 0xbb}
+	ipv6 := make([]byte, 36)
+	ipv6[0], ipv6[1], ipv6[15] = 0x20, 0x01, 1
+	ipv6[32], ipv6[33] = 0x03, 0xe8
+	tests := []struct {
+		name   string
+		header string
+		addr   string // 为空时出错，pipe为连接本身的地址
+	}{
+		{"v1 tcp4", "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n", "192.168.0.1:56324"},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", "[2001:db8::1]:56324"},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", "pipe"},
+		{"v1 no crlf", "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\n", ""},
+		{"v1 too long", "PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n", ""},
+		{"v1 bad ip", "PROXY TCP4 192.168.0 192.168.0.11 56324 443\r\n", ""},
+		{"v1 bad port", "PROXY TCP4 192.168.0.1 192.168.0.11 port 443\r\n", ""},
+		{"v1 bad proto", "PROXY UDP4 192.168.0.1 192.168.0.11 56324 443\r\n", ""},
+		{"v1 missing fields", "PROXY TCP4 192.168.0.1\r\n", ""},
+		{"v2 ipv4", string(proxyV2Header(0x21, 0x11, ipv4)), "10.0.0.1:1000"},
+		{"v2 ipv6", string(proxyV2Header(0x21, 0x21, ipv6)), "[2001::1]:1000"},
+		{"v2 local", string(proxyV2Header(0x20, 0x11, ipv4)), "pipe"},
+		{"v2 unspec", string(proxyV2Header(0x21, 0x00, nil)), "pipe"},
+		{"v2 bad version", string(proxyV2Header(0x11, 0x11, ipv4)), ""},
+		{"v2 short ipv4", string(proxyV2Header(0x21, 0x11, ipv4[:4])), ""},
+		{"v2 short ipv6", string(proxyV2Header(0x21, 0x21, ipv4)), ""},
+		{"v2 truncated", string(proxyV2Header(0x21, 0x11, ipv4))[:20], ""},
+		{"no header", "GET / HTTP/1.1\r\n\r\n", ""},
+	}
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		go func() {
+			client.Write([]byte(tt.header + "hello"))
+			client.Close()
+		}()
+
+		c, err := readProxyHeader(server)
+		if tt.addr == "" {
+			if err == nil {
+				t.Errorf("%s read invalid header", tt.name)
+			}
+			server.Close()
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s read header %v", tt.name, err)
+			server.Close()
+			continue
+		}
+		// 协议头后的数据不丢失
+		data, _ := io.ReadAll(c)
+		if addr := c.RemoteAddr().String(); addr != tt.addr || string(data) != "hello" {
+			t.Errorf("%s remote addr %s data %q", tt.name, addr, data)
+		}
+		c.Close()
+	}
+}
@@ -0,0 +1,188 @@
+package gateway
+
+// 客户端消息限流
+// 令牌桶分别限制每个会话、每个IP及每个会话的单个消息，规则见config.RateLimitConfig
+// 规则每次从当前配置读取，配置热更新后立即生效
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/guogeer/quasar/v2/config"
+	"github.com/guogeer/quasar/v2/utils"
+)
+
+const (
+	rateLimitWarn       = "warn"       // 仅打印日志
+	rateLimitDrop       = "drop"       // 丢弃消息
+	rateLimitDisconnect = "disconnect" // 断开连接
+
+	ipBucketIdleTime = time.Minute
+)
+
+// 未配置时每个会话2秒内最多96个消息
+var defaultSessionRateLimit = config.RateLimitRule{Rate: 48, Burst: 96, Action: rateLimitDisconnect}
+
+var actionLevels = map[string]int{
+	rateLimitWarn:       1,
+	rateLimitDrop:       2,
+	rateLimitDisconnect: 3,
+}
+
+type ruleBucket struct {
+	rule    config.RateLimitRule
+	b       *utils.TokenBucket
+	lastUse time.Time
+}
+
+func newRuleBucket(rule config.RateLimitRule) *ruleBucket {
+	return &ruleBucket{rule: rule, b: utils.NewTokenBucket(rule.Rate, rule.Burst)}
+}
+
+// 规则变化后保留已有的令牌
+func (rb *ruleBucket) allow(rule config.RateLimitRule, now time.Time) bool {
+	if rb.rule != rule {
+		rb.rule = rule
+		rb.b.SetLimit(rule.Rate, rule.Burst)
+	}
+	rb.lastUse = now
+	return rb.b.AllowN(now, 1)
+}
+
+// 会话的令牌桶，仅在读消息的协程中使用
+type sessionLimiter struct {
+	session  *ruleBucket
+	messages map[string]*ruleBucket // 规则的小写消息ID
+	ips      *ipLimiter             // 网关所有会话共用
+}
+
+func newSessionLimiter(ips *ipLimiter) *sessionLimiter {
+	return &sessionLimiter{messages: map[string]*ruleBucket{}, ips: ips}
+}
+
+type ipLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*ruleBucket
+	cleanTime time.Time
+}
+
+func (l *ipLimiter) allow(ip string, rule config.RateLimitRule, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// 清理长时间未使用的IP
+	if now.Sub(l.cleanTime) > ipBucketIdleTime {
+		l.cleanTime = now
+		for k, rb := range l.buckets {
+			if now.Sub(rb.lastUse) > ipBucketIdleTime {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	rb, ok := l.buckets[ip]
+	if !ok {
+		rb = newRuleBucket(rule)
+		l.buckets[ip] = rb
+	}
+	return rb.allow(rule, now)
+}
+
+// 未配置容量时，容量为每秒生成的令牌数，至少为1
+func normalizeRule(rule config.RateLimitRule) config.RateLimitRule {
+	if rule.Rate > 0 && rule.Burst <= 0 {
+		rule.Burst = max(1, int(math.Ceil(rule.Rate)))
+	}
+	return rule
+}
+
+// 规范化的规则，消息ID为小写。配置变化后重新生成
+type rateLimitRules struct {
+	conf     *config.Env
+	session  config.RateLimitRule
+	ip       config.RateLimitRule
+	messages map[string]config.RateLimitRule
+}
+
+var lastRateLimitRules atomic.Pointer[rateLimitRules]
+
+func loadRateLimitRules(conf *config.Env) *rateLimitRules {
+	if rules := lastRateLimitRules.Load(); rules != nil && rules.conf == conf {
+		return rules
+	}
+	rules := &rateLimitRules{
+		conf:     conf,
+		session:  normalizeRule(conf.RateLimit.Session),
+		ip:       normalizeRule(conf.RateLimit.IP),
+		messages: map[string]config.RateLimitRule{},
+	}
+	if rules.session.Rate == 0 {
+		rules.session = defaultSessionRateLimit
+	}
+	for k, rule := range conf.RateLimit.Messages {
+		rules.messages[strings.ToLower(k)] = normalizeRule(rule)
+	}
+	lastRateLimitRules.Store(rules)
+	return rules
+}
+
+// 匹配消息的规则，返回规则的消息ID。hall.c2s_login匹配c2s_login
+func matchMessageRule(rules map[string]config.RateLimitRule, msgId string) (string, config.RateLimitRule, bool) {
+	if len(rules) == 0 {
+		return "", config.RateLimitRule{}, false
+	}
+	msgId = strings.ToLower(msgId)
+	for {
+		if rule, ok := rules[msgId]; ok {
+			return msgId, rule, true
+		}
+		_, name, ok := strings.Cut(msgId, ".")
+		if !ok {
+			return "", config.RateLimitRule{}, false
+		}
+		msgId = name
+	}
+}
+
+// 检查消息是否超过限制，返回最严重的处理方式及超限的范围
+func (sl *sessionLimiter) check(ip, msgId string) (string, string) {
+	rules := loadRateLimitRules(config.Config())
+	sessionRule := rules.session
+
+	var action, scope string
+	exceed := func(rule config.RateLimitRule, s string) {
+		ruleAction := rule.Action
+		if ruleAction == "" {
+			ruleAction = rateLimitDrop
+		}
+		if actionLevels[ruleAction] > actionLevels[action] {
+			action, scope = ruleAction, s
+		}
+	}
+
+	now := time.Now()
+	if sl.session == nil {
+		sl.session = newRuleBucket(sessionRule)
+	}
+	if !sl.session.allow(sessionRule, now) {
+		exceed(sessionRule, "session")
+	}
+	if rules.ip.Rate > 0 && !sl.ips.allow(ip, rules.ip, now) {
+		exceed(rules.ip, "ip")
+	}
+	// 按匹配的规则计数，消息ID的大小写及服务名不影响
+	if key, rule, ok := matchMessageRule(rules.messages, msgId); ok && rule.Rate > 0 {
+		rb, ok := sl.messages[key]
+		if !ok {
+			rb = newRuleBucket(rule)
+			sl.messages[key] = rb
+		}
+		if !rb.allow(rule, now) {
+			exceed(rule, "message")
+		}
+	}
+	return action, scope
+}
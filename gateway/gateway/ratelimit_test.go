@@ -0,0 +1,80 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/guogeer/quasar/v2/config"
+)
+
+func TestMatchMessageRule(t *testing.T) {
+	rules := map[string]config.RateLimitRule{
+		"c2s_chat":       {Rate: 1},
+		"hall.c2s_login": {Rate: 2},
+	}
+	for _, c := range []struct {
+		msgId string
+		key   string
+	}{
+		{"c2s_chat", "c2s_chat"},
+		{"C2S_Chat", "c2s_chat"},
+		{"hall.c2s_chat", "c2s_chat"},
+		{"Hall.C2S_Login", "hall.c2s_login"},
+		{"room.c2s_login", ""},
+		{"c2s_enter", ""},
+	} {
+		key, _, ok := matchMessageRule(rules, c.msgId)
+		if key != c.key || ok != (c.key != "") {
+			t.Errorf("match %s => %s %v, expect %s", c.msgId, key, ok, c.key)
+		}
+	}
+}
+
+func TestNormalizeRule(t *testing.T) {
+	for _, c := range []struct {
+		rule  config.RateLimitRule
+		burst int
+	}{
+		{config.RateLimitRule{}, 0},
+		{config.RateLimitRule{Rate: 0.5}, 1},
+		{config.RateLimitRule{Rate: 2.5}, 3},
+		{config.RateLimitRule{Rate: 10}, 10},
+		{config.RateLimitRule{Rate: 10, Burst: 5}, 5},
+	} {
+		if rule := normalizeRule(c.rule); rule.Burst != c.burst {
+			t.Errorf("normalize %+v => burst %d, expect %d", c.rule, rule.Burst, c.burst)
+		}
+	}
+}
+
+func TestSessionLimiter(t *testing.T) {
+	oldConf := config.Config()
+	defer config.SetConfig(oldConf)
+	config.SetConfig(&config.Env{RateLimit: config.RateLimitConfig{
+		Session: config.RateLimitRule{Rate: 1000, Burst: 1000},
+		Messages: map[string]config.RateLimitRule{
+			"C2S_Chat": {Rate: 0.001, Burst: 2, Action: rateLimitDisconnect},
+			"c2s_move": {Rate: 5, Action: rateLimitDisconnect}, // 未配置容量
+		},
+	}})
+
+	sl := newSessionLimiter(&ipLimiter{buckets: map[string]*ruleBucket{}})
+	// 大小写及服务名不同的消息共用同一个令牌桶
+	for i, msgId := range []string{"c2s_chat", "C2S_CHAT", "hall.c2s_chat", "Hall.C2s_Chat"} {
+		action, scope := sl.check("127.0.0.1", msgId)
+		if i < 2 && action != "" {
+			t.Errorf("message %s limited %s %s", msgId, action, scope)
+		}
+		if i >= 2 && (action != rateLimitDisconnect || scope != "message") {
+			t.Errorf("message %s not limited %s %s", msgId, action, scope)
+		}
+	}
+	if len(sl.messages) != 1 {
+		t.Errorf("message buckets %d", len(sl.messages))
+	}
+	if action, _ := sl.check("127.0.0.1", "c2s_enter"); action != "" {
+		t.Errorf("unmatched message limited %s", action)
+	}
+	if action, _ := sl.check("127.0.0.1", "c2s_move"); action != "" {
+		t.Errorf("message without burst limited %s", action)
+	}
+}
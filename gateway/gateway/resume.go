@@ -0,0 +1,451 @@
+package gateway
+
+// 断线重连恢复会话
+// 1、建立连接后下发消息resumeToken：{"token":"...","gracePeriod":30}
+// 2、断线后gracePeriod秒内保留会话及关联的业务服，期间发往客户端的消息按序缓存
+// 3、客户端重连时携带参数?resume={token}，恢复原会话并补发缓存的消息，结果通过消息resume回复
+// 4、重连到其他网关时，新网关通过路由向原网关迁移会话，原网关此后收到的消息转发至新网关
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/config"
+	"github.com/guogeer/quasar/v2/log"
+)
+
+const (
+	resumeWait              = 5 * time.Second // 等待原网关迁移会话
+	defaultResumeBufferSize = 256
+)
+
+var (
+	errInvalidResumeToken = errors.New("invalid resume token")
+	errResumeBufferFull   = errors.New("resume buffer is full")
+	errResumeTimeout      = errors.New("resume timeout")
+)
+
+type resumeArgs struct {
+	Ssid          string          `json:"ssid,omitempty"`
+	Secret        string          `json:"secret,omitempty"`
+	GatewayId     string          `json:"gatewayId,omitempty"` // 迁移的目标网关
+	UserId        string          `json:"userId,omitempty"`
+	ServerName    string          `json:"serverName,omitempty"`
+	MatchServerId string          `json:"matchServerId,omitempty"`
+	SelectKey     string          `json:"selectKey,omitempty"` // 灰度规则选择会话的键
+	Messages      []resumeMessage `json:"messages,omitempty"`
+	Channels      []string        `json:"channels,omitempty"`
+	Error         string          `json:"error,omitempty"`
+}
+
+// 缓存及转发的消息，保留消息ID用于发送队列合并消息
+type resumeMessage struct {
+	Id   string `json:"id,omitempty"`
+	Data []byte `json:"data,omitempty"`
+}
+
+func resumeConfig() (time.Duration, int) {
+	conf := config.Config().Resume
+	bufferSize := conf.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultResumeBufferSize
+	}
+	return time.Duration(conf.GracePeriod) * time.Second, bufferSize
+}
+
+func newResumeSecret() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// 凭证：base64(网关ID\n会话ID\n密钥)
+func encodeResumeToken(gatewayId, ssid, secret string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(gatewayId + "\n" + ssid + "\n" + secret))
+}
+
+func decodeResumeToken(token string) (string, string, string, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", "", "", errInvalidResumeToken
+	}
+	parts := strings.Split(string(buf), "\n")
+	if len(parts) != 3 {
+		return "", "", "", errInvalidResumeToken
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+// 会话的连接。客户端断线后缓存消息，重连后替换为新的客户端连接
+type sessionConn struct {
+	g           *Gateway
+	mu          sync.Mutex
+	ssid        string
+	secret      string
+	userId      string     // 认证后的用户ID
+	selectKey   string     // 灰度规则选择会话的键，首次匹配服务时确定，认证后不变
+	out         clientConn // 当前客户端连接，断线后为空
+	buffer      []resumeMessage
+	isOverflow  bool   // 缓存已满，不可恢复
+	movedTo     string // 会话已迁移到其他网关
+	movedIn     bool   // 从其他网关迁移的会话
+	expireTimer *time.Timer
+}
+
+func (g *Gateway) newSessionConn(ssid, userId string) *sessionConn {
+	return &sessionConn{g: g, ssid: ssid, userId: userId, secret: newResumeSecret()}
+}
+
+func (sc *sessionConn) Write(data []byte) error {
+	return sc.writeMsg("", data)
+}
+
+// 发送或缓存消息，id为消息ID。不持有锁写连接及转发
+func (sc *sessionConn) writeMsg(id string, data []byte) error {
+	sc.mu.Lock()
+	out, movedTo := sc.out, sc.movedTo
+	if out != nil || movedTo != "" {
+		sc.mu.Unlock()
+		if out != nil {
+			return out.writeMsg(id, data)
+		}
+		sc.g.forwardResumeMessages(movedTo, sc.ssid, []resumeMessage{{Id: id, Data: data}})
+		return nil
+	}
+	defer sc.mu.Unlock()
+	if sc.isOverflow {
+		return errResumeBufferFull
+	}
+	if _, bufferSize := resumeConfig(); len(sc.buffer) >= bufferSize {
+		sc.isOverflow = true
+		sc.buffer = nil
+		return errResumeBufferFull
+	}
+	sc.buffer = append(sc.buffer, resumeMessage{Id: id, Data: data})
+	return nil
+}
+
+func (sc *sessionConn) WriteJSON(name string, i any) error {
+	// 保留消息ID，发送队列据此合并消息
+	sc.mu.Lock()
+	if out := sc.out; out != nil {
+		defer sc.mu.Unlock()
+		return out.WriteJSON(name, i)
+	}
+	sc.mu.Unlock()
+
+	buf, err := cmd.EncodePackage(&cmd.Package{Id: name, Body: i})
+	if err != nil {
+		return err
+	}
+	return sc.writeMsg(name, buf)
+}
+
+func (sc *sessionConn) RemoteAddr() string {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.out == nil {
+		return ""
+	}
+	return sc.out.RemoteAddr()
+}
+
+func (sc *sessionConn) Close() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.out != nil {
+		sc.out.Close()
+	}
+}
+
+func (sc *sessionConn) setUserId(userId string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.userId = userId
+}
+
+func (sc *sessionConn) UserId() string {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.userId
+}
+
+// 灰度规则选择会话的键。首次调用时已认证为用户ID，否则为会话ID
+func (sc *sessionConn) canaryKey() string {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.selectKey == "" {
+		sc.selectKey = sc.userId
+		if sc.selectKey == "" {
+			sc.selectKey = sc.ssid
+		}
+	}
+	return sc.selectKey
+}
+
+func (sc *sessionConn) ResumeToken() string {
+	return encodeResumeToken(sc.g.opts.Id, sc.ssid, sc.secret)
+}
+
+// 客户端连接断开。返回true时需立即关闭会话
+func (sc *sessionConn) detach(c clientConn) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	// 已被新连接替换
+	if sc.out != c {
+		return false
+	}
+	sc.out = nil
+
+	gracePeriod, _ := resumeConfig()
+	if gracePeriod <= 0 || sc.movedTo != "" {
+		return true
+	}
+	sc.expireTimer = time.AfterFunc(gracePeriod, sc.expire)
+	return false
+}
+
+// 超过保留时间未重连，关闭会话
+func (sc *sessionConn) expire() {
+	sc.mu.Lock()
+	if sc.out != nil {
+		sc.mu.Unlock()
+		return
+	}
+	isMoved := sc.movedTo != ""
+	userId := sc.userId
+	sc.mu.Unlock()
+
+	g := sc.g
+	g.set.RemoveSession(sc.ssid)
+	// 会话迁移后由新网关通知业务服
+	if isMoved {
+		g.sessionLocations.Delete(sc.ssid)
+		return
+	}
+	log.Debugf("session %s resume expire", sc.ssid)
+	g.set.Handle(&cmd.Context{Ssid: sc.ssid, Out: sc, UserId: userId}, "func_close", nil)
+}
+
+func (sc *sessionConn) checkResumeLocked(secret string) error {
+	if subtle.ConstantTimeCompare([]byte(sc.secret), []byte(secret)) != 1 {
+		return errInvalidResumeToken
+	}
+	if sc.out != nil || sc.movedTo != "" {
+		return errInvalidResumeToken
+	}
+	if sc.isOverflow {
+		return errResumeBufferFull
+	}
+	return nil
+}
+
+// 新连接恢复会话，回复resume后按序补发缓存的消息
+func (sc *sessionConn) attach(c clientConn, secret string) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if err := sc.checkResumeLocked(secret); err != nil {
+		return err
+	}
+	if sc.expireTimer != nil {
+		sc.expireTimer.Stop()
+	}
+	sc.out = c
+	c.WriteJSON("resume", resumeReply(sc.ssid, len(sc.buffer), nil))
+	for _, msg := range sc.buffer {
+		c.writeMsg(msg.Id, msg.Data)
+	}
+	sc.buffer = nil
+	return nil
+}
+
+func (sc *sessionConn) isMovedIn() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.movedIn
+}
+
+func (sc *sessionConn) isMoved() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.movedTo != ""
+}
+
+// 会话迁移到其他网关，返回缓存的消息
+func (sc *sessionConn) moveTo(gatewayId, secret string) ([]resumeMessage, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if err := sc.checkResumeLocked(secret); err != nil {
+		return nil, err
+	}
+	if sc.expireTimer != nil {
+		sc.expireTimer.Stop()
+	}
+	// 保留一段时间用于转发业务服推送的消息
+	gracePeriod, _ := resumeConfig()
+	sc.expireTimer = time.AfterFunc(gracePeriod, sc.expire)
+
+	sc.movedTo = gatewayId
+	buffer := sc.buffer
+	sc.buffer = nil
+	return buffer, nil
+}
+
+// 原网关转发迁移后收到的消息，会话ID由路由放入消息头
+func (g *Gateway) forwardResumeMessages(gatewayId, ssid string, messages []resumeMessage) {
+	args := &resumeArgs{Messages: messages}
+	g.set.Route("router", "c2s_route", cmd.M{"serverId": gatewayId, "msgId": "func_resumeRoute", "msgData": args, "ssid": ssid})
+}
+
+func resumeReply(ssid string, count int, err error) *cmd.Reply {
+	if err != nil {
+		return &cmd.Reply{Code: "resume_failed", Msg: err.Error()}
+	}
+	return &cmd.Reply{Data: cmd.M{"ssid": ssid, "count": count}}
+}
+
+func (g *Gateway) getSessionConn(ssid string) *sessionConn {
+	if ss := g.set.GetSession(ssid); ss != nil {
+		sc, _ := ss.Out.(*sessionConn)
+		return sc
+	}
+	return nil
+}
+
+// 根据凭证恢复会话
+func (g *Gateway) resumeSession(c clientConn, token string) (*sessionConn, error) {
+	gatewayId, ssid, secret, err := decodeResumeToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if gatewayId == g.opts.Id {
+		sc := g.getSessionConn(ssid)
+		if sc == nil {
+			return nil, errInvalidResumeToken
+		}
+		return sc, sc.attach(c, secret)
+	}
+
+	// 从其他网关迁移
+	wait := make(chan *sessionConn, 1)
+	if _, ok := g.resumeWaits.LoadOrStore(ssid, wait); ok {
+		return nil, errInvalidResumeToken
+	}
+
+	args := &resumeArgs{Ssid: ssid, Secret: secret, GatewayId: g.opts.Id}
+	g.set.Route("router", "c2s_route", cmd.M{"serverId": gatewayId, "msgId": "func_resume", "msgData": args})
+	var sc *sessionConn
+	select {
+	case sc = <-wait:
+	case <-time.After(resumeWait):
+		// 已取走等待的迁移结果随后发送，不丢弃迁移的会话
+		if _, ok := g.resumeWaits.LoadAndDelete(ssid); ok {
+			return nil, errResumeTimeout
+		}
+		sc = <-wait
+	}
+	if sc == nil {
+		return nil, errInvalidResumeToken
+	}
+	if err := sc.attach(c, sc.secret); err != nil {
+		g.closeResumedSession(sc)
+		return nil, err
+	}
+	return sc, nil
+}
+
+// 关闭迁移后未恢复的会话
+func (g *Gateway) closeResumedSession(sc *sessionConn) {
+	g.set.RemoveSession(sc.ssid)
+	g.set.Handle(&cmd.Context{Ssid: sc.ssid, Out: sc, UserId: sc.UserId()}, "func_close", nil)
+}
+
+// 原网关迁移会话
+func (g *Gateway) funcResume(ctx *cmd.Context, data any) {
+	args := data.(*resumeArgs)
+
+	reply := &resumeArgs{Ssid: args.Ssid}
+	sc := g.getSessionConn(args.Ssid)
+	if sc == nil {
+		reply.Error = errInvalidResumeToken.Error()
+	} else if buffer, err := sc.moveTo(args.GatewayId, args.Secret); err != nil {
+		reply.Error = err.Error()
+	} else {
+		log.Debugf("session %s move to gateway %s", args.Ssid, args.GatewayId)
+		reply.UserId = sc.UserId()
+		reply.SelectKey = sc.canaryKey()
+		reply.Messages = buffer
+		reply.Channels = g.leaveAllChannels(args.Ssid)
+		if v, ok := g.sessionLocations.Load(args.Ssid); ok {
+			loc := v.(*sessionLocation)
+			reply.ServerName, reply.MatchServerId = loc.ServerName, loc.MatchServerId
+		}
+	}
+	g.set.Route("router", "c2s_route", cmd.M{"serverId": args.GatewayId, "msgId": "func_resumeResult", "msgData": reply})
+}
+
+// 通知等待迁移的连接，sc为空时迁移失败
+func signalResumeWait(v any, sc *sessionConn) {
+	select {
+	case v.(chan *sessionConn) <- sc:
+	default:
+	}
+}
+
+// 新网关收到迁移的会话。结果仅被等待的连接或超时后的关闭处理一次
+func (g *Gateway) funcResumeResult(ctx *cmd.Context, data any) {
+	args := data.(*resumeArgs)
+	v, isWaiting := g.resumeWaits.LoadAndDelete(args.Ssid)
+	if args.Error != "" {
+		log.Debugf("session %s resume error %s", args.Ssid, args.Error)
+		if isWaiting {
+			signalResumeWait(v, nil)
+		}
+		return
+	}
+
+	if isWaiting && g.set.GetSession(args.Ssid) != nil {
+		signalResumeWait(v, nil)
+		return
+	}
+	// 先创建会话，原网关随后转发的消息进入缓存
+	sc := g.newSessionConn(args.Ssid, args.UserId)
+	sc.movedIn = true
+	sc.selectKey = args.SelectKey
+	sc.buffer = args.Messages
+	// 关闭时通知会话所在的业务服
+	if args.MatchServerId != "" {
+		g.sessionLocations.Store(args.Ssid, &sessionLocation{ServerName: args.ServerName, MatchServerId: args.MatchServerId})
+	}
+	// 等待超时，已迁移的会话直接关闭
+	if !isWaiting {
+		log.Debugf("session %s resume result after timeout", args.Ssid)
+		g.set.Handle(&cmd.Context{Ssid: args.Ssid, Out: sc, UserId: args.UserId}, "func_close", nil)
+		return
+	}
+	g.set.AddSession(&cmd.Session{Id: args.Ssid, Out: sc})
+	g.bindUser(args.Ssid, args.UserId)
+	for _, channel := range args.Channels {
+		g.joinChannel(args.Ssid, channel)
+	}
+	signalResumeWait(v, sc)
+}
+
+// 原网关转发的消息。会话ID取自路由转发的消息头，仅迁移来的会话接收
+func (g *Gateway) funcResumeRoute(ctx *cmd.Context, data any) {
+	args := data.(*resumeArgs)
+	sc := g.getSessionConn(ctx.Ssid)
+	if sc == nil || !sc.isMovedIn() {
+		return
+	}
+	for _, msg := range args.Messages {
+		sc.writeMsg(msg.Id, msg.Data)
+	}
+}
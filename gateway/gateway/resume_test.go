@@ -0,0 +1,159 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/guogeer/quasar/v2/cmd"
+)
+
+type testConn struct {
+	msgs []*cmd.Package
+	ids  []string // writeMsg写入的消息ID
+}
+
+func (c *testConn) writeMsg(id string, buf []byte) error {
+	c.ids = append(c.ids, id)
+	return c.Write(buf)
+}
+
+func (c *testConn) Write(buf []byte) error {
+	pkg := &cmd.Package{}
+	if err := json.Unmarshal(buf, pkg); err != nil {
+		return err
+	}
+	c.msgs = append(c.msgs, pkg)
+	return nil
+}
+
+func (c *testConn) WriteJSON(msgId string, i any) error {
+	buf, err := cmd.EncodePackage(&cmd.Package{Id: msgId, Body: i})
+	if err != nil {
+		return err
+	}
+	return c.Write(buf)
+}
+
+func (c *testConn) RemoteAddr() string { return "127.0.0.1:1" }
+func (c *testConn) Close()             {}
+
+func handleResume(g *Gateway, ssid, msgId string, args *resumeArgs) {
+	buf, _ := json.Marshal(args)
+	g.set.Handle(&cmd.Context{Out: &testConn{}, Ssid: ssid}, msgId, buf)
+}
+
+func TestResumeResult(t *testing.T) {
+	g := New(Options{Id: "gw_2"})
+
+	// 等待的连接收到迁移的会话
+	wait := make(chan *sessionConn, 1)
+	g.resumeWaits.Store("ss1", wait)
+	handleResume(g, "", "func_resumeResult", &resumeArgs{Ssid: "ss1", Messages: []resumeMessage{{Id: "a", Data: []byte(`{"id":"a"}`)}}})
+	sc := <-wait
+	if sc == nil || g.getSessionConn("ss1") != sc || len(sc.buffer) != 1 {
+		t.Fatalf("resume session %v", sc)
+	}
+	if _, ok := g.resumeWaits.Load("ss1"); ok {
+		t.Error("resume wait not removed")
+	}
+
+	// 会话已存在时通知失败，不等待超时
+	g.resumeWaits.Store("ss1", wait)
+	handleResume(g, "", "func_resumeResult", &resumeArgs{Ssid: "ss1"})
+	if sc := <-wait; sc != nil {
+		t.Error("resume existing session")
+	}
+
+	// 迁移失败
+	g.resumeWaits.Store("ss2", wait)
+	handleResume(g, "", "func_resumeResult", &resumeArgs{Ssid: "ss2", Error: errInvalidResumeToken.Error()})
+	if sc := <-wait; sc != nil {
+		t.Error("resume fail session")
+	}
+
+	// 等待超时后收到的会话不加入
+	handleResume(g, "", "func_resumeResult", &resumeArgs{Ssid: "ss3"})
+	if g.getSessionConn("ss3") != nil {
+		t.Error("resume session after timeout")
+	}
+}
+
+// 等待超时后收到的会话关闭，并通知业务服
+func TestResumeResultTimeout(t *testing.T) {
+	g := New(Options{Id: "gw_2"})
+	path := filepath.Join(t.TempDir(), "record.jsonl")
+	rec, err := cmd.NewRecorder(path, "gw_2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.set.SetRecorder(rec)
+	handleResume(g, "", "func_resumeResult", &resumeArgs{Ssid: "ss1", UserId: "1001", ServerName: "hall", MatchServerId: "hall_5"})
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if g.getSessionConn("ss1") != nil {
+		t.Error("resume session after timeout")
+	}
+	if _, ok := g.sessionLocations.Load("ss1"); ok {
+		t.Error("session location not removed")
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var isClosed bool
+	for reader := cmd.NewRecordReader(bytes.NewReader(buf)); ; {
+		r, err := reader.Next()
+		if err != nil {
+			break
+		}
+		if r.Out && r.To == "hall_5" && r.Id == "close" && r.Ssid == "ss1" {
+			isClosed = true
+		}
+	}
+	if !isClosed {
+		t.Errorf("session close not routed to server %s", buf)
+	}
+}
+
+func TestResumeRoute(t *testing.T) {
+	g := New(Options{Id: "gw_2"})
+	movedIn := g.newSessionConn("ss1", "")
+	movedIn.movedIn = true
+	local := g.newSessionConn("ss2", "")
+	g.set.AddSession(&cmd.Session{Id: "ss1", Out: movedIn})
+	g.set.AddSession(&cmd.Session{Id: "ss2", Out: local})
+
+	// 会话ID取自消息头，参数中的会话ID无效
+	msgs := []resumeMessage{{Id: "a", Data: []byte(`{"id":"a"}`)}}
+	handleResume(g, "ss1", "func_resumeRoute", &resumeArgs{Ssid: "ss2", Messages: msgs})
+	handleResume(g, "ss2", "func_resumeRoute", &resumeArgs{Messages: msgs})
+	if len(movedIn.buffer) != 1 || movedIn.buffer[0].Id != "a" || len(local.buffer) != 0 {
+		t.Errorf("resume route moved in %d, local %d", len(movedIn.buffer), len(local.buffer))
+	}
+}
+
+func TestSessionConnMsgId(t *testing.T) {
+	g := New(Options{Id: "gw_1"})
+	sc := g.newSessionConn("ss1", "")
+
+	// 断线时缓存的消息保留消息ID，恢复后按序补发
+	sc.WriteJSON("s2c_a", cmd.M{})
+	sc.Write([]byte(`{"id":"s2c_b"}`))
+	c := &testClientConn{}
+	if err := sc.attach(c, sc.secret); err != nil {
+		t.Fatal(err)
+	}
+	sc.WriteJSON("s2c_c", cmd.M{})
+	if ids := strings.Join(c.ids, ","); ids != "s2c_a," {
+		t.Errorf("write msg ids %s", ids)
+	}
+	if len(c.msgs) != 4 || c.msgs[0].Id != "resume" {
+		t.Errorf("attach messages %d", len(c.msgs))
+	}
+}
@@ -0,0 +1,187 @@
+package gateway
+
+// 客户端的发送队列。队列满时不阻塞写入，按配置丢弃消息或断开连接
+// 避免单个网络慢的客户端阻塞广播等消息的处理
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/guogeer/quasar/v2/config"
+)
+
+const (
+	sendQueueDropNewest  = "dropNewest"
+	sendQueueDropOldest  = "dropOldest"
+	sendQueueDisconnect  = "disconnect"
+	defaultSendQueueSize = 1 << 10
+)
+
+var (
+	errSendQueueClosed = errors.New("send queue is closed")
+	errSendQueueFull   = errors.New("send queue is full")
+	errSlowConsumer    = errors.New("slow consumer")
+)
+
+type sendItem struct {
+	id   string // 消息ID，用于合并消息
+	data []byte
+}
+
+type sendQueue struct {
+	mu        sync.Mutex
+	items     []sendItem
+	isClose   bool
+	fullSince time.Time // 队列开始持续满的时间
+	notify    chan struct{}
+
+	size              int
+	policy            string
+	saturationTimeout time.Duration
+	coalesce          map[string]bool
+
+	stats *sendQueueStats
+}
+
+// 网关所有连接的发送队列统计
+type sendQueueStats struct {
+	dropped      atomic.Int64
+	coalesced    atomic.Int64
+	disconnected atomic.Int64
+}
+
+func newSendQueue(conf config.SendQueueConfig, stats *sendQueueStats) *sendQueue {
+	q := &sendQueue{
+		notify:            make(chan struct{}, 1),
+		size:              conf.Size,
+		policy:            conf.Policy,
+		saturationTimeout: time.Duration(conf.SaturationTimeout) * time.Second,
+		coalesce:          map[string]bool{},
+		stats:             stats,
+	}
+	if q.size <= 0 {
+		q.size = defaultSendQueueSize
+	}
+	if q.policy == "" {
+		q.policy = sendQueueDropNewest
+	}
+	for _, id := range conf.Coalesce {
+		q.coalesce[id] = true
+	}
+	return q
+}
+
+func (q *sendQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// 写入消息。返回errSlowConsumer时需断开连接
+func (q *sendQueue) push(id string, data []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.isClose {
+		return errSendQueueClosed
+	}
+
+	if id != "" && q.coalesce[id] {
+		for i := range q.items {
+			if q.items[i].id == id {
+				q.items[i].data = data
+				q.stats.coalesced.Add(1)
+				return nil
+			}
+		}
+	}
+
+	if len(q.items) >= q.size {
+		now := time.Now()
+		if q.fullSince.IsZero() {
+			q.fullSince = now
+		}
+		if q.policy == sendQueueDisconnect || (q.saturationTimeout > 0 && now.Sub(q.fullSince) >= q.saturationTimeout) {
+			q.stats.disconnected.Add(1)
+			return errSlowConsumer
+		}
+		q.stats.dropped.Add(1)
+		if q.policy != sendQueueDropOldest {
+			return errSendQueueFull
+		}
+		q.items = q.items[1:]
+	}
+	q.items = append(q.items, sendItem{id: id, data: data})
+	q.signal()
+	return nil
+}
+
+// 取出最多n个消息，消息总长度超过maxSize后不再取。队列已关闭且为空时isClose为true
+func (q *sendQueue) pop(n, maxSize int) (bufs [][]byte, isClose bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var size int
+	for len(bufs) < n && size < maxSize && len(bufs) < len(q.items) {
+		buf := q.items[len(bufs)].data
+		bufs = append(bufs, buf)
+		size += len(buf)
+	}
+	clear(q.items[:len(bufs)])
+	q.items = q.items[len(bufs):]
+	if len(q.items) < q.size {
+		q.fullSince = time.Time{}
+	}
+	return bufs, q.isClose && len(bufs) == 0
+}
+
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.isClose {
+		q.isClose = true
+		q.signal()
+	}
+}
+
+// 队列中的消息数
+func (q *sendQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+type SendQueueStats struct {
+	Conns        int    `json:"conns"`
+	Queued       int    `json:"queued"`       // 队列中未发送的消息数
+	Saturated    int    `json:"saturated"`    // 队列已满的连接数
+	FillBuckets  [5]int `json:"fillBuckets"`  // 队列占用0~25%、25~50%、50~75%、75~100%、满的连接数
+	Dropped      int64  `json:"dropped"`      // 丢弃的消息数
+	Coalesced    int64  `json:"coalesced"`    // 合并的消息数
+	Disconnected int64  `json:"disconnected"` // 因队列满断开的连接数
+}
+
+// WebSocket客户端发送队列的统计，用于发现网络差的客户端
+func (g *Gateway) SendQueueStats() SendQueueStats {
+	stats := SendQueueStats{
+		Dropped:      g.sendStats.dropped.Load(),
+		Coalesced:    g.sendStats.coalesced.Load(),
+		Disconnected: g.sendStats.disconnected.Load(),
+	}
+	g.wsConns.Range(func(k, _ any) bool {
+		q := k.(*WsConn).queue
+		n := q.len()
+		stats.Conns++
+		stats.Queued += n
+		if n >= q.size {
+			stats.Saturated++
+			stats.FillBuckets[4]++
+		} else {
+			stats.FillBuckets[n*4/q.size]++
+		}
+		return true
+	})
+	return stats
+}
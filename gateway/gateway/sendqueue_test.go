@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/guogeer/quasar/v2/config"
+)
+
+func queueIds(q *sendQueue) string {
+	var ids []string
+	for _, item := range q.items {
+		ids = append(ids, item.id+":"+string(item.data))
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestSendQueuePolicy(t *testing.T) {
+	tests := []struct {
+		name  string
+		conf  config.SendQueueConfig
+		err   error
+		items string
+		stats [3]int64 // 丢弃、合并、断开
+	}{
+		{"dropNewest", config.SendQueueConfig{Size: 2}, errSendQueueFull, "a:1,b:2", [3]int64{2, 0, 0}},
+		{"dropOldest", config.SendQueueConfig{Size: 2, Policy: sendQueueDropOldest}, nil, "c:3,a:4", [3]int64{2, 0, 0}},
+		{"disconnect", config.SendQueueConfig{Size: 2, Policy: sendQueueDisconnect}, errSlowConsumer, "a:1,b:2", [3]int64{0, 0, 2}},
+		{"coalesce", config.SendQueueConfig{Size: 2, Coalesce: []string{"a"}}, errSendQueueFull, "a:4,b:2", [3]int64{1, 1, 0}},
+	}
+	for _, tt := range tests {
+		stats := &sendQueueStats{}
+		q := newSendQueue(tt.conf, stats)
+		q.push("a", []byte("1"))
+		q.push("b", []byte("2"))
+		err := q.push("c", []byte("3"))
+		q.push("a", []byte("4"))
+		if err != tt.err {
+			t.Errorf("%s push error %v", tt.name, err)
+		}
+		if items := queueIds(q); items != tt.items {
+			t.Errorf("%s queue items %s", tt.name, items)
+		}
+		if got := [3]int64{stats.dropped.Load(), stats.coalesced.Load(), stats.disconnected.Load()}; got != tt.stats {
+			t.Errorf("%s queue stats %v", tt.name, got)
+		}
+	}
+}
+
+func TestSendQueueSaturation(t *testing.T) {
+	q := newSendQueue(config.SendQueueConfig{Size: 1, SaturationTimeout: 1}, &sendQueueStats{})
+	q.push("", []byte("1"))
+	if err := q.push("", []byte("2")); err != errSendQueueFull {
+		t.Fatalf("push full queue %v", err)
+	}
+	// 取出后不再持续满
+	q.pop(1, maxMessageSize)
+	if !q.fullSince.IsZero() {
+		t.Error("queue still saturated after pop")
+	}
+	q.push("", []byte("1"))
+	q.push("", []byte("2"))
+	q.fullSince = q.fullSince.Add(-2 * time.Second)
+	if err := q.push("", []byte("3")); err != errSlowConsumer {
+		t.Errorf("push saturated queue %v", err)
+	}
+}
+
+func TestSendQueuePop(t *testing.T) {
+	q := newSendQueue(config.SendQueueConfig{}, &sendQueueStats{})
+	for _, s := range []string{"aa", "bb", "cc", "dd"} {
+		q.push("", []byte(s))
+	}
+	// 数量及长度达到限制后不再取，至少取一个
+	if bufs, _ := q.pop(3, 100); len(bufs) != 3 {
+		t.Errorf("pop by count %d", len(bufs))
+	}
+	q.push("", []byte("ee"))
+	if bufs, _ := q.pop(10, 1); len(bufs) != 1 || string(bufs[0]) != "dd" {
+		t.Errorf("pop by size %q", bufs)
+	}
+	q.close()
+	if bufs, isClose := q.pop(10, 100); len(bufs) != 1 || isClose {
+		t.Errorf("pop closed queue %q %v", bufs, isClose)
+	}
+	if _, isClose := q.pop(10, 100); !isClose {
+		t.Error("pop empty closed queue")
+	}
+	if err := q.push("", nil); err != errSendQueueClosed {
+		t.Errorf("push closed queue %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package gateway
+
+// 网关
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/config"
+	"github.com/guogeer/quasar/v2/log"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait      = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingPeriod     = (pongWait * 9) / 10
+	maxMessageSize = 96 << 10 // 96K
+	sendQueueSize  = 16 << 10
+)
+
+// 客户端通过子协议协商消息格式，未指定时同quasar.json
+const (
+	subprotocolJSON      = "quasar.json"
+	subprotocolJSONBatch = "quasar.json.batch" // 多条消息合并为JSON数组
+	subprotocolBinary    = "quasar.binary"     // 二进制协议，多条消息合并为一帧
+)
+
+const (
+	defaultCompressThreshold = 512
+	defaultCompressLevel     = 1
+	defaultBatchSize         = 16
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	Subprotocols:    []string{subprotocolBinary, subprotocolJSONBatch, subprotocolJSON},
+}
+
+type WsConn struct {
+	ws    *websocket.Conn
+	queue *sendQueue
+
+	compressThreshold int // 小于0时不压缩
+	batchSize         int
+	remoteAddr        string // 客户端真实地址
+}
+
+func newWsConn(ws *websocket.Conn, conf config.WebSocketConfig, queue *sendQueue) *WsConn {
+	c := &WsConn{
+		ws:                ws,
+		queue:             queue,
+		compressThreshold: -1,
+		batchSize:         1,
+	}
+	if conf.Compress {
+		c.compressThreshold = conf.CompressThreshold
+		if c.compressThreshold <= 0 {
+			c.compressThreshold = defaultCompressThreshold
+		}
+		level := conf.CompressLevel
+		if level == 0 {
+			level = defaultCompressLevel
+		}
+		ws.SetCompressionLevel(level)
+	}
+	if p := ws.Subprotocol(); p == subprotocolBinary || p == subprotocolJSONBatch {
+		c.batchSize = conf.BatchSize
+		if c.batchSize <= 0 {
+			c.batchSize = defaultBatchSize
+		}
+	}
+	return c
+}
+
+func (c *WsConn) RemoteAddr() string {
+	if c.remoteAddr != "" {
+		return c.remoteAddr
+	}
+	return c.ws.RemoteAddr().String()
+}
+
+func (c *WsConn) Close() {
+	c.ws.Close()
+	c.queue.close()
+}
+
+func (c *WsConn) WriteJSON(name string, i any) error {
+	// 消息格式
+	pkg := &cmd.Package{Id: name, Body: i}
+	buf, err := cmd.EncodePackage(pkg)
+	if err != nil {
+		return err
+	}
+	return c.writeMsg(name, buf)
+}
+
+func (c *WsConn) Write(data []byte) error {
+	return c.writeMsg("", data)
+}
+
+// 写入发送队列，不阻塞
+func (c *WsConn) writeMsg(id string, data []byte) error {
+	err := c.queue.push(id, data)
+	if err == errSlowConsumer {
+		log.Warnf("client %s send queue is saturated, disconnect", c.RemoteAddr())
+		c.Close()
+	}
+	return err
+}
+
+func (c *WsConn) SetReadDeadline(t time.Time) error {
+	return c.ws.SetReadDeadline(t)
+}
+
+func (c *WsConn) ReadPackage() (*cmd.Package, error) {
+	mt, message, err := c.ws.ReadMessage()
+	if err != nil {
+		if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway) {
+			log.Debugf("websocket close, %v", err)
+		}
+		return nil, err
+	}
+
+	var pkg *cmd.Package
+	if mt == websocket.BinaryMessage {
+		pkg, err = cmd.DecodeBinary(message)
+	} else {
+		pkg, err = cmd.Decode(message)
+	}
+	if err != nil {
+		log.Warn(err)
+		return nil, err
+	}
+	return pkg, nil
+}
+
+func (c *WsConn) writeMessage(mt int, payload []byte) error {
+	c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	if mt == websocket.TextMessage || mt == websocket.BinaryMessage {
+		c.ws.EnableWriteCompression(c.compressThreshold >= 0 && len(payload) >= c.compressThreshold)
+	}
+	return c.ws.WriteMessage(mt, payload)
+}
+
+// 发送队列中的消息。客户端支持时合并多条消息为一帧
+func (c *WsConn) flush() error {
+	for {
+		bufs, isClose := c.queue.pop(c.batchSize, maxMessageSize)
+		if isClose {
+			return errSendQueueClosed
+		}
+		if len(bufs) == 0 {
+			return nil
+		}
+		mt, frame := c.encodeFrame(bufs)
+		if err := c.writeMessage(mt, frame); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *WsConn) encodeFrame(bufs [][]byte) (mt int, frame []byte) {
+	switch c.ws.Subprotocol() {
+	case subprotocolBinary:
+		for _, buf := range bufs {
+			pkg := &cmd.Package{}
+			if err := json.Unmarshal(buf, pkg); err != nil {
+				log.Warnf("encode binary message %v", err)
+				continue
+			}
+			frame, _ = cmd.AppendBinary(frame, pkg)
+		}
+		return websocket.BinaryMessage, frame
+	case subprotocolJSONBatch:
+		frame = append(frame, '[')
+		frame = append(frame, bytes.Join(bufs, []byte{','})...)
+		frame = append(frame, ']')
+		return websocket.TextMessage, frame
+	}
+	return websocket.TextMessage, bufs[0]
+}
+
+func (g *Gateway) serveWs(w http.ResponseWriter, r *http.Request) {
+	auth, err := g.authenticator()
+	if err != nil {
+		log.Errorf("create authenticator error %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	accessConf := config.Config().Access
+	clientAddr := requestClientAddr(accessConf, r)
+	if err := g.admit(accessConf, clientAddr); err != nil {
+		log.Debugf("client %s connect error %v", clientAddr, err)
+		code := http.StatusForbidden
+		if err != errAccessDenied {
+			code = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	defer g.conns.release(hostIP(clientAddr))
+
+	// 建立连接时认证
+	var userId string
+	if token := requestToken(r); auth != nil && token != "" {
+		userId, err = auth.Authenticate(&AuthRequest{Token: token, ClientAddr: clientAddr})
+		if err != nil {
+			log.Debugf("client %s authenticate error %v", clientAddr, err)
+			http.Error(w, cmd.CodeUnauthorized, http.StatusUnauthorized)
+			return
+		}
+	}
+
+	wsConf := config.Config().WebSocket
+	connUpgrader := upgrader
+	connUpgrader.EnableCompression = wsConf.Compress
+	connUpgrader.CheckOrigin = func(r *http.Request) bool { return checkOrigin(accessConf, r) }
+	ws, err := connUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := newWsConn(ws, wsConf, newSendQueue(config.Config().SendQueue, g.sendStats))
+	c.remoteAddr = clientAddr
+	g.wsConns.Store(c, true)
+	defer g.wsConns.Delete(c)
+
+	doneCtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(pingPeriod)
+		defer func() {
+			c.Close()
+			ticker.Stop() // 关闭定时器
+		}()
+
+		for {
+			select {
+			case <-c.queue.notify:
+				if err := c.flush(); err != nil {
+					if err != errSendQueueClosed {
+						log.Debug("write message", err)
+					}
+					return
+				}
+			case <-ticker.C:
+				if err := c.writeMessage(websocket.PingMessage, nil); err != nil {
+					return
+				}
+			case <-doneCtx.Done():
+				return
+			}
+		}
+	}()
+	defer cancel()
+
+	c.ws.SetReadLimit(4 << 10)
+	c.ws.SetPongHandler(func(string) error {
+		c.ws.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+	g.serveClient(c, auth, userId, r.URL.Query().Get("resume"))
+}
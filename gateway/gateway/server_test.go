@@ -0,0 +1,168 @@
+package gateway
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/config"
+
+	"github.com/gorilla/websocket"
+)
+
+// 统计客户端读取的字节数
+type countConn struct {
+	net.Conn
+	n atomic.Int64
+}
+
+func (c *countConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// 建立WebSocket连接，返回网关的连接及客户端的连接
+func dialTestWs(t *testing.T, conf config.WebSocketConfig, subprotocol string) (*WsConn, *websocket.Conn, *countConn) {
+	conns := make(chan *WsConn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connUpgrader := upgrader
+		connUpgrader.EnableCompression = conf.Compress
+		ws, err := connUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- newWsConn(ws, conf, newSendQueue(config.SendQueueConfig{}, &sendQueueStats{}))
+	}))
+	t.Cleanup(srv.Close)
+
+	var counter *countConn
+	dialer := websocket.Dialer{
+		EnableCompression: true,
+		NetDial: func(network, addr string) (net.Conn, error) {
+			c, err := net.Dial(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			counter = &countConn{Conn: c}
+			return counter, nil
+		},
+	}
+	if subprotocol != "" {
+		dialer.Subprotocols = []string{subprotocol}
+	}
+	ws, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := <-conns
+	t.Cleanup(func() {
+		ws.Close()
+		c.Close()
+	})
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	counter.n.Store(0)
+	return c, ws, counter
+}
+
+// 解析一帧中的二进制消息
+func decodeBinaryFrame(frame []byte) ([]string, bool) {
+	var ids []string
+	for len(frame) > 0 {
+		var fields [3][]byte
+		for i := range fields {
+			n, size := binary.Uvarint(frame)
+			if size <= 0 || uint64(len(frame)-size) < n {
+				return nil, false
+			}
+			fields[i], frame = frame[size:size+int(n)], frame[size+int(n):]
+		}
+		ids = append(ids, string(fields[0])+string(fields[2]))
+	}
+	return ids, true
+}
+
+func TestWsConnBatch(t *testing.T) {
+	tests := []struct {
+		subprotocol string
+		batchSize   int
+		frames      []string
+	}{
+		{"", 8, []string{`{"id":"a","data":1}`, `{"id":"b","data":2}`, `{"id":"c","data":3}`}},
+		{subprotocolJSON, 8, []string{`{"id":"a","data":1}`, `{"id":"b","data":2}`, `{"id":"c","data":3}`}},
+		{subprotocolJSONBatch, 8, []string{`[{"id":"a","data":1},{"id":"b","data":2},{"id":"c","data":3}]`}},
+		{subprotocolJSONBatch, 2, []string{`[{"id":"a","data":1},{"id":"b","data":2}]`, `[{"id":"c","data":3}]`}},
+		{subprotocolBinary, 8, []string{"a1,b2,c3"}},
+		{subprotocolBinary, 0, []string{"a1,b2,c3"}}, // 默认合并16条
+	}
+	for _, tt := range tests {
+		c, ws, _ := dialTestWs(t, config.WebSocketConfig{BatchSize: tt.batchSize}, tt.subprotocol)
+		c.WriteJSON("a", 1)
+		c.WriteJSON("b", 2)
+		c.WriteJSON("c", 3)
+		if err := c.flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		var frames []string
+		for len(frames) < len(tt.frames) {
+			mt, frame, err := ws.ReadMessage()
+			if err != nil {
+				t.Fatalf("%s read frame %v", tt.subprotocol, err)
+			}
+			if mt == websocket.BinaryMessage {
+				ids, ok := decodeBinaryFrame(frame)
+				if !ok {
+					t.Fatalf("%s invalid binary frame", tt.subprotocol)
+				}
+				frame = []byte(strings.Join(ids, ","))
+			}
+			frames = append(frames, string(frame))
+		}
+		if got, want := strings.Join(frames, "\n"), strings.Join(tt.frames, "\n"); got != want {
+			t.Errorf("%s batch %d frames %s", tt.subprotocol, tt.batchSize, got)
+		}
+	}
+}
+
+func TestWsConnCompress(t *testing.T) {
+	text := strings.Repeat("a", 10000)
+	tests := []struct {
+		name       string
+		conf       config.WebSocketConfig
+		compressed bool
+	}{
+		{"disabled", config.WebSocketConfig{}, false},
+		{"enabled", config.WebSocketConfig{Compress: true}, true},
+		{"under threshold", config.WebSocketConfig{Compress: true, CompressThreshold: 20000}, false},
+		{"level", config.WebSocketConfig{Compress: true, CompressLevel: 9}, true},
+	}
+	for _, tt := range tests {
+		c, ws, counter := dialTestWs(t, tt.conf, "")
+		if err := c.WriteJSON("big", cmd.M{"text": text}); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.flush(); err != nil {
+			t.Fatal(err)
+		}
+		_, frame, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("%s read frame %v", tt.name, err)
+		}
+		pkg := &cmd.Package{}
+		var data struct{ Text string }
+		if json.Unmarshal(frame, pkg) != nil || json.Unmarshal(pkg.Data, &data) != nil || data.Text != text {
+			t.Fatalf("%s invalid frame", tt.name)
+		}
+		if compressed := counter.n.Load() < int64(len(text)); compressed != tt.compressed {
+			t.Errorf("%s compressed %v, read %d bytes", tt.name, compressed, counter.n.Load())
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package gateway
+
+// TCP、KCP客户端连接。协议格式同cmd.TCPConn：4字节协议头+JSON消息，客户端主动发送心跳
+// 建立连接后客户端先发送消息，断线重连时第一个消息为resume：{"token":"..."}
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/config"
+	"github.com/guogeer/quasar/v2/log"
+)
+
+type streamConn struct {
+	*cmd.TCPConn
+	first *cmd.Package // 第一个消息不是resume时暂存
+}
+
+type resumeTokenArgs struct {
+	Token string `json:"token,omitempty"`
+}
+
+// 无发送队列，忽略消息ID
+func (c *streamConn) writeMsg(id string, data []byte) error {
+	return c.Write(data)
+}
+
+func (c *streamConn) ReadPackage() (*cmd.Package, error) {
+	if pkg := c.first; pkg != nil {
+		c.first = nil
+		return pkg, nil
+	}
+	for {
+		mt, buf, err := c.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		// 忽略心跳
+		if mt != cmd.RawMessage {
+			continue
+		}
+		pkg, err := cmd.Decode(buf)
+		if err != nil {
+			log.Warn(err)
+			return nil, err
+		}
+		return pkg, nil
+	}
+}
+
+// TCP、KCP客户端连接
+func (g *Gateway) ServeStream(l net.Listener) error {
+	defer l.Close()
+	for {
+		rwc, err := l.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go g.handleStream(rwc)
+	}
+}
+
+// 监听TCP、KCP客户端连接，network：tcp|kcp
+func (g *Gateway) ListenAndServeStream(network, addr string) error {
+	var l net.Listener
+	var err error
+	switch network {
+	case "tcp":
+		l, err = net.Listen("tcp", addr)
+	case "kcp":
+		l, err = listenKCP(addr)
+	default:
+		return errors.New("unsupported network " + network)
+	}
+	if err != nil {
+		return err
+	}
+	return g.ServeStream(l)
+}
+
+func (g *Gateway) handleStream(rwc net.Conn) {
+	auth, err := g.authenticator()
+	if err != nil {
+		log.Errorf("create authenticator error %v", err)
+		rwc.Close()
+		return
+	}
+
+	accessConf := config.Config().Access
+	if accessConf.ProxyProtocol && isTrustedProxy(accessConf, rwc.RemoteAddr().String()) {
+		proxyRwc, err := readProxyHeader(rwc)
+		if err != nil {
+			log.Debugf("client %s read proxy header error %v", rwc.RemoteAddr(), err)
+			rwc.Close()
+			return
+		}
+		rwc = proxyRwc
+	}
+	clientAddr := rwc.RemoteAddr().String()
+	if err := g.admit(accessConf, clientAddr); err != nil {
+		log.Debugf("client %s connect error %v", clientAddr, err)
+		rwc.Close()
+		return
+	}
+	defer g.conns.release(hostIP(clientAddr))
+
+	c := &streamConn{TCPConn: cmd.NewTCPConn(rwc, "")}
+	doneCtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		defer c.Close()
+		c.WriteLoop(doneCtx)
+	}()
+	defer cancel()
+
+	c.SetReadDeadline(time.Now().Add(pongWait))
+	pkg, err := c.ReadPackage()
+	if err != nil {
+		c.Close()
+		return
+	}
+	var resumeToken string
+	args := &resumeTokenArgs{}
+	if pkg.Id == "resume" && json.Unmarshal(pkg.Data, args) == nil {
+		resumeToken = args.Token
+	} else {
+		c.first = pkg
+	}
+	g.serveClient(c, auth, "", resumeToken)
+}
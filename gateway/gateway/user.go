@@ -0,0 +1,44 @@
+package gateway
+
+// 向路由上报认证后的用户，用于按用户ID推送消息
+
+import (
+	"github.com/guogeer/quasar/v2/cmd"
+)
+
+func (g *Gateway) bindUser(ssid, userId string) {
+	if userId != "" {
+		g.set.Route("router", "c2s_bindUser", cmd.M{"userId": userId, "ssid": ssid})
+	}
+}
+
+func (g *Gateway) unbindUser(ssid, userId string) {
+	if userId != "" {
+		g.set.Route("router", "c2s_unbindUser", cmd.M{"userId": userId, "ssid": ssid})
+	}
+}
+
+// 网关注册后路由查询在线用户
+func (g *Gateway) s2cQueryUsers(ctx *cmd.Context, data any) {
+	users := []cmd.M{}
+	for _, ss := range g.set.GetSessionList() {
+		sc, ok := ss.Out.(*sessionConn)
+		if !ok || sc.isMoved() {
+			continue
+		}
+		if userId := sc.UserId(); userId != "" {
+			users = append(users, cmd.M{"userId": userId, "ssid": ss.Id})
+		}
+	}
+	g.set.Route("router", "c2s_syncUsers", cmd.M{"users": users})
+}
+
+// 推送消息到指定的会话
+func (g *Gateway) funcPush(ctx *cmd.Context, data any) {
+	args := data.(*gatewayArgs)
+	for _, ssid := range args.Ssids {
+		if ss := g.set.GetSession(ssid); ss != nil {
+			ss.Out.WriteJSON(args.Id, args.Data)
+		}
+	}
+}
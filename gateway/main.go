@@ -6,7 +6,9 @@ import (
 	"net/http"
 	"runtime"
 
+	"github.com/guogeer/quasar/v2"
 	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/gateway/gateway"
 	"github.com/guogeer/quasar/v2/log"
 	"github.com/guogeer/quasar/v2/utils"
 )
@@ -16,25 +18,51 @@ var port = flag.Int("port", 8201, "gateway server port")
 var proxy = flag.String("proxy", "", "gateway server proxy addr")
 var minWeight = flag.Int("min_weight", 0, "gateway server min weight")
 var maxWeight = flag.Int("max_weight", 0, "gateway server max weight")
+var tcpPort = flag.Int("tcp_port", 0, "gateway tcp client port, 0 disable")
+var kcpPort = flag.Int("kcp_port", 0, "gateway kcp client port, 0 disable")
+var enableSchema = flag.Bool("schema", false, "serve message schema at /schema for stubgen")
 
 func main() {
+	if err := quasar.Init(quasar.Options{ParseCommandLine: true, Watch: true}); err != nil {
+		log.Error(err)
+	}
 	flag.Parse()
 
 	log.Infof("start gateway, listen %d", *port)
 	addr := fmt.Sprintf("%s:%d", *proxy, *port)
-	cmd.RegisterService(&cmd.ServiceConfig{
+	gw := gateway.New(gateway.Options{
 		Id:        *id,
-		Name:      "gateway",
 		Addr:      addr,
 		MinWeight: *minWeight,
 		MaxWeight: *maxWeight,
 	})
+	gw.Start()
 
+	http.Handle("/ws", gw)
+	if *enableSchema {
+		http.Handle("/schema", cmd.SchemaHandler(gw.CmdSet()))
+	}
 	go func() {
 		if err := http.ListenAndServe(fmt.Sprintf(":%d", *port), nil); err != nil {
 			log.Fatal(err)
 		}
 	}()
+	if *tcpPort > 0 {
+		log.Infof("listen tcp %d", *tcpPort)
+		go func() {
+			if err := gw.ListenAndServeStream("tcp", fmt.Sprintf(":%d", *tcpPort)); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+	if *kcpPort > 0 {
+		log.Infof("listen kcp %d", *kcpPort)
+		go func() {
+			if err := gw.ListenAndServeStream("kcp", fmt.Sprintf(":%d", *kcpPort)); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
 
 	defer func() {
 		if err := recover(); err != nil {
@@ -0,0 +1,32 @@
+// 进程初始化
+// 导入quasar的包不再读取命令行参数及配置文件，需显式调用Init或config.Load
+
+package quasar
+
+import (
+	"github.com/guogeer/quasar/v2/config"
+)
+
+type Options struct {
+	ConfigPath       string // 配置文件路径。为空且未解析命令行时不加载文件
+	ParseCommandLine bool   // 解析命令行参数-config、-log-level等，同旧版本导入config包的行为
+	Watch            bool   // 配置文件变化后热更新
+}
+
+func Init(opts Options) error {
+	if opts.ParseCommandLine {
+		if err := config.ParseCommandLine(); err != nil {
+			return err
+		}
+	} else {
+		conf, err := config.Load(opts.ConfigPath)
+		if err != nil {
+			return err
+		}
+		config.SetConfig(conf)
+	}
+	if opts.Watch {
+		config.Watch()
+	}
+	return nil
+}
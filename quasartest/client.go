@@ -0,0 +1,155 @@
+package quasartest
+
+// 模拟的WebSocket客户端。收到的消息按顺序保存，Expect按消息ID等待
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/guogeer/quasar/v2/cmd"
+)
+
+// 会话收到的消息
+type Message struct {
+	Id   string
+	Data json.RawMessage
+}
+
+type Client struct {
+	t       testing.TB
+	ws      *websocket.Conn
+	timeout time.Duration
+
+	mu       sync.Mutex
+	history  []*Message // 收到的所有消息
+	pending  []*Message // 未被Expect取出的消息
+	notify   chan struct{}
+	isClose  bool
+	writeMu  sync.Mutex
+	closeErr error
+}
+
+// 连接网关，header可携带认证的token等。测试结束后自动关闭
+func (c *Cluster) Dial(header http.Header) *Client {
+	c.t.Helper()
+	ws, _, err := websocket.DefaultDialer.Dial(c.GatewayURL, header)
+	if err != nil {
+		c.t.Fatalf("dial gateway %v", err)
+	}
+	client := &Client{t: c.t, ws: ws, timeout: c.opts.Timeout, notify: make(chan struct{}, 1)}
+	c.t.Cleanup(client.Close)
+	go client.readLoop()
+	return client
+}
+
+func (client *Client) readLoop() {
+	for {
+		_, buf, err := client.ws.ReadMessage()
+		if err != nil {
+			client.mu.Lock()
+			client.isClose, client.closeErr = true, err
+			client.mu.Unlock()
+			client.signal()
+			return
+		}
+		pkg := &cmd.Package{}
+		if err := json.Unmarshal(buf, pkg); err != nil {
+			continue
+		}
+		msg := &Message{Id: pkg.Id, Data: pkg.Data}
+		client.mu.Lock()
+		client.history = append(client.history, msg)
+		client.pending = append(client.pending, msg)
+		client.mu.Unlock()
+		client.signal()
+	}
+}
+
+func (client *Client) signal() {
+	select {
+	case client.notify <- struct{}{}:
+	default:
+	}
+}
+
+// 发送消息，如hall.c2s_enter，失败时测试失败
+func (client *Client) Send(msgId string, i any) {
+	client.t.Helper()
+	buf, err := cmd.Encode(msgId, i)
+	if err != nil {
+		client.t.Fatalf("encode %s %v", msgId, err)
+	}
+	client.writeMu.Lock()
+	defer client.writeMu.Unlock()
+	if err := client.ws.WriteMessage(websocket.TextMessage, buf); err != nil {
+		client.t.Fatalf("send %s %v", msgId, err)
+	}
+}
+
+// 等待指定ID的消息，先收到的其他消息保留。超时后测试失败
+func (client *Client) Expect(msgId string) *Message {
+	client.t.Helper()
+	deadline := time.NewTimer(client.timeout)
+	defer deadline.Stop()
+	for {
+		client.mu.Lock()
+		k := slices.IndexFunc(client.pending, func(msg *Message) bool { return msg.Id == msgId })
+		if k >= 0 {
+			msg := client.pending[k]
+			client.pending = slices.Delete(client.pending, k, k+1)
+			client.mu.Unlock()
+			return msg
+		}
+		isClose, closeErr := client.isClose, client.closeErr
+		client.mu.Unlock()
+		if isClose {
+			client.t.Fatalf("wait message %s, connection closed %v", msgId, closeErr)
+		}
+
+		select {
+		case <-client.notify:
+		case <-deadline.C:
+			client.t.Fatalf("wait message %s timeout, received %v", msgId, client.Received())
+		}
+	}
+}
+
+// 等待消息并解析数据
+func (client *Client) ExpectJSON(msgId string, v any) {
+	client.t.Helper()
+	msg := client.Expect(msgId)
+	if err := json.Unmarshal(msg.Data, v); err != nil {
+		client.t.Fatalf("decode message %s %s %v", msgId, msg.Data, err)
+	}
+}
+
+// 在一段时间内未收到指定ID的消息，收到时测试失败
+func (client *Client) ExpectNone(msgId string, d time.Duration) {
+	client.t.Helper()
+	time.Sleep(d)
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if slices.ContainsFunc(client.pending, func(msg *Message) bool { return msg.Id == msgId }) {
+		client.t.Fatalf("unexpected message %s", msgId)
+	}
+}
+
+// 已收到的消息ID
+func (client *Client) Received() []string {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	ids := make([]string, 0, len(client.history))
+	for _, msg := range client.history {
+		ids = append(ids, msg.Id)
+	}
+	return ids
+}
+
+func (client *Client) Close() {
+	client.ws.Close()
+}
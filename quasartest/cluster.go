@@ -0,0 +1,194 @@
+// 集成测试工具。在同一进程中启动路由、网关及业务服，均监听随机端口
+// 1、各组件使用独立的CmdSet及消息队列，不依赖配置文件及默认的路由地址
+// 2、消息队列及定时器在集群的主循环中处理。定时器为全局的，集群不可并行测试
+// 3、模拟的WebSocket客户端经网关发送消息，并断言会话收到的消息
+
+package quasartest
+
+import (
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/gateway/gateway"
+	"github.com/guogeer/quasar/v2/router/router"
+	"github.com/guogeer/quasar/v2/utils"
+)
+
+const defaultTimeout = 3 * time.Second
+
+type Options struct {
+	Gateway gateway.Options // 网关参数，Addr、CmdSet由集群设置
+	Timeout time.Duration   // 等待消息及服务注册的超时时间。默认3s
+}
+
+type Cluster struct {
+	t    testing.TB
+	opts Options
+
+	Router     *router.Router
+	RouterAddr string
+	Gateway    *gateway.Gateway
+	GatewayURL string // WebSocket地址，如ws://127.0.0.1:1234/ws
+
+	queue   *cmd.MsgQueue
+	calls   chan func()
+	stop    chan struct{}
+	done    chan struct{}
+	mu      sync.Mutex
+	closers []func()
+	isClose bool
+}
+
+// 业务服
+type Service struct {
+	Id     string
+	Name   string
+	Addr   string
+	CmdSet *cmd.CmdSet
+}
+
+// 启动路由及网关，测试结束后自动关闭
+func NewCluster(t testing.TB, opts Options) *Cluster {
+	t.Helper()
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	c := &Cluster{
+		t:     t,
+		opts:  opts,
+		queue: cmd.NewMsgQueue(1 << 10),
+		calls: make(chan func()),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go c.run()
+	t.Cleanup(c.Close)
+
+	// 路由
+	l := c.listen()
+	c.RouterAddr = l.Addr().String()
+	c.Router = router.New(router.Options{CmdSet: c.newCmdSet()})
+	c.Do(c.Router.Start)
+	go c.Router.Serve(l)
+
+	// 网关
+	l = c.listen()
+	gwOpts := opts.Gateway
+	gwOpts.Addr = l.Addr().String()
+	gwOpts.CmdSet = c.newCmdSet()
+	c.Gateway = gateway.New(gwOpts)
+	c.GatewayURL = "ws://" + gwOpts.Addr + "/ws"
+	srv := &http.Server{Handler: c.Gateway}
+	c.addCloser(func() { srv.Close() })
+	go srv.Serve(l)
+	c.Do(c.Gateway.Start)
+	c.WaitServers(c.Gateway.Id())
+	return c
+}
+
+func (c *Cluster) listen() net.Listener {
+	c.t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.addCloser(func() { l.Close() })
+	return l
+}
+
+// 使用集群的消息队列及路由地址
+func (c *Cluster) newCmdSet() *cmd.CmdSet {
+	set := cmd.NewCmdSet()
+	set.SetMsgQueue(c.queue)
+	set.SetRouterAddr(c.RouterAddr)
+	c.addCloser(set.Close)
+	return set
+}
+
+func (c *Cluster) addCloser(f func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closers = append(c.closers, f)
+}
+
+// 主循环，处理消息队列、定时器及Do的调用
+func (c *Cluster) run() {
+	defer close(c.done)
+	for {
+		select {
+		case <-c.stop:
+			return
+		case f := <-c.calls:
+			f()
+		default:
+		}
+		utils.GetTimerSet().RunOnce()
+		c.queue.RunOnce()
+	}
+}
+
+// 在主循环中执行，与队列中的消息处理及定时器不会并发
+func (c *Cluster) Do(f func()) {
+	done := make(chan struct{})
+	select {
+	case c.calls <- func() { defer close(done); f() }:
+		<-done
+	case <-c.done:
+	}
+}
+
+// 启动业务服并注册到路由，等待网关同步后返回。bind在注册前绑定消息
+func (c *Cluster) AddService(id, name string, bind func(set *cmd.CmdSet)) *Service {
+	c.t.Helper()
+	l := c.listen()
+	svc := &Service{Id: id, Name: name, Addr: l.Addr().String(), CmdSet: c.newCmdSet()}
+	if bind != nil {
+		bind(svc.CmdSet)
+	}
+	srv := &cmd.Server{CmdSet: svc.CmdSet}
+	go srv.Serve(l)
+	svc.CmdSet.RegisterService(&cmd.ServiceConfig{Id: id, Name: name, Addr: svc.Addr})
+	c.WaitServers(id)
+	return svc
+}
+
+// 等待网关同步服务，超时后测试失败
+func (c *Cluster) WaitServers(ids ...string) {
+	c.t.Helper()
+	deadline := time.Now().Add(c.opts.Timeout)
+	for {
+		serverIds := c.Gateway.ServerIds()
+		if !slices.ContainsFunc(ids, func(id string) bool { return !slices.Contains(serverIds, id) }) {
+			return
+		}
+		if time.Now().After(deadline) {
+			c.t.Fatalf("wait servers %v timeout, gateway servers %v", ids, serverIds)
+		}
+		// 主动同步，不等待路由通知
+		c.Gateway.CmdSet().Route("router", "c2s_queryServerState", cmd.M{})
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 关闭所有的连接并停止主循环
+func (c *Cluster) Close() {
+	c.mu.Lock()
+	if c.isClose {
+		c.mu.Unlock()
+		return
+	}
+	c.isClose = true
+	closers := c.closers
+	c.mu.Unlock()
+
+	for i := len(closers) - 1; i >= 0; i-- {
+		closers[i]()
+	}
+	close(c.stop)
+	<-c.done
+}
@@ -0,0 +1,85 @@
+package quasartest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/gateway/gateway"
+	"github.com/guogeer/quasar/v2/quasartest"
+)
+
+type enterArgs struct {
+	RoomId int    `json:"roomId,omitempty"`
+	Msg    string `json:"msg,omitempty"`
+}
+
+func TestCluster(t *testing.T) {
+	c := quasartest.NewCluster(t, quasartest.Options{})
+	c.AddService("hall_1", "hall", func(set *cmd.CmdSet) {
+		cmd.BindTypedTo(set, "enter", func(ctx *cmd.Context, args *enterArgs) (*enterArgs, error) {
+			// 经路由通知房间服
+			set.Forward("room", "func_enter", args)
+			return &enterArgs{RoomId: args.RoomId}, nil
+		})
+	})
+	rooms := make(chan int, 1)
+	c.AddService("room_1", "room", func(set *cmd.CmdSet) {
+		set.Bind("func_enter", func(ctx *cmd.Context, data any) {
+			rooms <- data.(*enterArgs).RoomId
+		}, (*enterArgs)(nil), cmd.WithPrivate())
+		cmd.BindTypedTo(set, "chat", func(ctx *cmd.Context, args *enterArgs) (*enterArgs, error) {
+			return nil, cmd.NewError("muted", "user is muted")
+		})
+	})
+
+	client := c.Dial(nil)
+	client.Send("hall.enter", enterArgs{RoomId: 1001})
+	reply := &cmd.Reply{Data: &enterArgs{}}
+	client.ExpectJSON("s2c_enter", reply)
+	if reply.Data.(*enterArgs).RoomId != 1001 {
+		t.Errorf("enter room %+v", reply.Data)
+	}
+	select {
+	case roomId := <-rooms:
+		if roomId != 1001 {
+			t.Errorf("forward room %d", roomId)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("wait forward timeout")
+	}
+
+	client.Send("room.chat", enterArgs{Msg: "hello"})
+	reply = &cmd.Reply{}
+	client.ExpectJSON("s2c_chat", reply)
+	if reply.Code != "muted" {
+		t.Errorf("chat reply %+v", reply)
+	}
+
+	// 无效的服务
+	client.Send("shop.buy", struct{}{})
+	client.Expect("serverClose")
+}
+
+func TestClusterAuth(t *testing.T) {
+	c := quasartest.NewCluster(t, quasartest.Options{Gateway: gateway.Options{
+		Authenticator: gateway.AuthFunc(func(req *gateway.AuthRequest) (string, error) {
+			return req.Token, nil
+		}),
+	}})
+	c.AddService("hall_1", "hall", func(set *cmd.CmdSet) {
+		cmd.BindTypedTo(set, "whoami", func(ctx *cmd.Context, args *struct{}) (string, error) {
+			return ctx.UserId, nil
+		})
+	})
+
+	client := c.Dial(nil)
+	client.Send("auth", map[string]string{"token": "1001"})
+	client.Expect("auth")
+	client.Send("hall.whoami", struct{}{})
+	reply := &cmd.Reply{}
+	client.ExpectJSON("s2c_whoami", reply)
+	if reply.Data != "1001" {
+		t.Errorf("user id %v", reply.Data)
+	}
+}
@@ -4,18 +4,29 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"runtime"
 	"strconv"
+	"time"
 
+	"github.com/guogeer/quasar/v2"
 	"github.com/guogeer/quasar/v2/cmd"
 	"github.com/guogeer/quasar/v2/config"
 	"github.com/guogeer/quasar/v2/log"
+	"github.com/guogeer/quasar/v2/router/router"
 	"github.com/guogeer/quasar/v2/utils"
 )
 
 var port = flag.Int("port", 9003, "router server port")
+var adminAddr = flag.String("admin_addr", "", "router admin http addr, such as 127.0.0.1:9004, empty disable")
+var adminToken = flag.String("admin_token", "", "router admin http token")
+var snapshot = flag.String("snapshot", "", "router registry snapshot file, empty disable")
+var reconcileWindow = flag.Int("reconcile", 30, "seconds to wait for restored servers to register again")
 
 func main() {
+	if err := quasar.Init(quasar.Options{ParseCommandLine: true, Watch: true}); err != nil {
+		log.Error(err)
+	}
 	flag.Parse()
 
 	addr := config.Config().Server("router").Addr
@@ -23,9 +34,25 @@ func main() {
 	if portStr != "" {
 		*port, _ = strconv.Atoi(portStr)
 	}
+
 	log.Infof("start router server, listen %d", *port)
+	r := router.New(router.Options{
+		Snapshot:        *snapshot,
+		ReconcileWindow: time.Duration(*reconcileWindow) * time.Second,
+	})
+	r.Start()
+	if *adminAddr != "" {
+		log.Infof("start router admin, listen %s", *adminAddr)
+		go func() {
+			if err := http.ListenAndServe(*adminAddr, r.AdminHandler(*adminToken)); err != nil {
+				log.Error(err)
+			}
+		}()
+	}
 	go func() {
-		cmd.ListenAndServe(fmt.Sprintf(":%d", *port))
+		if err := r.ListenAndServe(fmt.Sprintf(":%d", *port)); err != nil {
+			log.Fatal(err)
+		}
 	}()
 
 	defer func() {
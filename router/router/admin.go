@@ -0,0 +1,164 @@
+package router
+
+// 管理后台HTTP接口及状态页面
+// 接口加锁处理，与路由的消息处理不会并发访问服务列表
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guogeer/quasar/v2/api"
+	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/log"
+)
+
+//go:embed admin.html
+var adminHTML []byte
+
+var errServerNotFound = errors.New("server not found")
+
+type adminArgs struct {
+	Id     string `json:"id,omitempty" form:"id" binding:"required"`
+	Cancel bool   `json:"cancel,omitempty" form:"cancel"` // 取消下线
+}
+
+type listArgs struct{}
+
+type serverInfo struct {
+	Id          string            `json:"id"`
+	Name        string            `json:"name"`
+	Addr        string            `json:"addr"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Weight      int               `json:"weight"` // 网关为会话数
+	MinWeight   int               `json:"minWeight"`
+	MaxWeight   int               `json:"maxWeight"`
+	Unhealthy   bool              `json:"unhealthy"`
+	Draining    bool              `json:"draining"`
+	Users       int               `json:"users"` // 网关上认证的用户会话数
+	ConnectTime time.Time         `json:"connectTime"`
+	ReportTime  time.Time         `json:"reportTime"`
+}
+
+func (r *Router) listServers() []serverInfo {
+	users := map[string]int{}
+	for _, sessions := range r.userSessions {
+		for _, gatewayId := range sessions {
+			users[gatewayId]++
+		}
+	}
+
+	infos := []serverInfo{}
+	for _, server := range r.servers {
+		infos = append(infos, serverInfo{
+			Id:          server.id,
+			Name:        server.name,
+			Addr:        server.addr,
+			Labels:      server.labels,
+			Weight:      server.weight,
+			MinWeight:   server.minWeight,
+			MaxWeight:   server.maxWeight,
+			Unhealthy:   server.unhealthy,
+			Draining:    server.draining,
+			Users:       users[server.id],
+			ConnectTime: server.connectTime,
+			ReportTime:  server.reportTime,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Id < infos[j].Id })
+	return infos
+}
+
+// 管理接口的处理函数，在路由的锁中调用
+type adminFunc func(args *adminArgs) ([]serverInfo, error)
+
+func (r *Router) adminServers(args *adminArgs) ([]serverInfo, error) {
+	return r.listServers(), nil
+}
+
+// 下线服务，不再分配新的请求，已有的会话不受影响
+func (r *Router) adminDrain(args *adminArgs) ([]serverInfo, error) {
+	server, ok := r.servers[args.Id]
+	if !ok {
+		return nil, errServerNotFound
+	}
+	log.Infof("admin drain server %s cancel %v", server.id, args.Cancel)
+	server.draining = !args.Cancel
+	r.syncServerStates()
+	return r.listServers(), nil
+}
+
+// 断开服务的连接，服务会自动重连并重新注册
+func (r *Router) adminKick(args *adminArgs) ([]serverInfo, error) {
+	server, ok := r.servers[args.Id]
+	if !ok {
+		return nil, errServerNotFound
+	}
+	log.Infof("admin kick server %s", server.id)
+	server.out.Close()
+	return r.listServers(), nil
+}
+
+// 从服务列表中移除，连接保持不变。服务重连后重新注册
+func (r *Router) adminUnregister(args *adminArgs) ([]serverInfo, error) {
+	server, ok := r.servers[args.Id]
+	if !ok {
+		return nil, errServerNotFound
+	}
+	log.Infof("admin unregister server %s", server.id)
+	r.unregisterServer(server)
+	r.syncServerStates()
+	return r.listServers(), nil
+}
+
+// 加锁处理请求。不经过路由的CmdSet，服务无法发送管理消息
+func (r *Router) handleAdmin(f adminFunc) api.Handler {
+	return func(c *api.Context, data any) (any, error) {
+		args, _ := data.(*adminArgs)
+		if args == nil {
+			args = &adminArgs{}
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return f(args)
+	}
+}
+
+// 设置token时请求需携带Authorization: Bearer {token}或参数token
+func adminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reqToken := c.Query("token")
+		if auth := c.GetHeader("Authorization"); auth != "" {
+			reqToken = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if token != "" && subtle.ConstantTimeCompare([]byte(reqToken), []byte(token)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Next()
+	}
+}
+
+// 管理后台，token为空时不校验
+func (r *Router) AdminHandler(token string) http.Handler {
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	engine.Use(gin.Recovery(), adminAuth(token))
+	engine.GET("/", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", adminHTML)
+	})
+
+	engine.GET("/api/schema", gin.WrapH(cmd.SchemaHandler(r.set)))
+
+	group := api.NewGroup("/api", engine.Group("/api"))
+	group.GET("/servers", r.handleAdmin(r.adminServers), (*listArgs)(nil))
+	group.POST("/servers/drain", r.handleAdmin(r.adminDrain), (*adminArgs)(nil))
+	group.POST("/servers/kick", r.handleAdmin(r.adminKick), (*adminArgs)(nil))
+	group.POST("/servers/unregister", r.handleAdmin(r.adminUnregister), (*adminArgs)(nil))
+	return engine
+}
@@ -0,0 +1,100 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/guogeer/quasar/v2/cmd"
+)
+
+type testConn struct {
+	isClose bool
+}
+
+func (c *testConn) Write([]byte) error                 { return nil }
+func (c *testConn) WriteJSON(name string, i any) error { return nil }
+func (c *testConn) RemoteAddr() string                 { return "127.0.0.1:1" }
+func (c *testConn) Close()                             { c.isClose = true }
+
+func newAdminRouter() *Router {
+	r := newCanaryRouter()
+	for _, server := range r.servers {
+		server.out = &testConn{}
+	}
+	return r
+}
+
+func TestAdminAuth(t *testing.T) {
+	h := newAdminRouter().AdminHandler("secret")
+	tests := []struct {
+		name   string
+		path   string
+		auth   string
+		status int
+	}{
+		{"no token", "/api/servers", "", http.StatusUnauthorized},
+		{"wrong token", "/api/servers", "Bearer wrong", http.StatusUnauthorized},
+		{"prefix token", "/api/servers", "Bearer secre", http.StatusUnauthorized},
+		{"header token", "/api/servers", "Bearer secret", http.StatusOK},
+		{"query token", "/api/servers?token=secret", "", http.StatusOK},
+		{"header first", "/api/servers?token=secret", "Bearer wrong", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s status %d", tt.name, w.Code)
+		}
+	}
+}
+
+func TestAdminServers(t *testing.T) {
+	r := newAdminRouter()
+	h := r.AdminHandler("")
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(http.MethodGet, "/api/servers", "")
+	var servers []serverInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &servers); err != nil || len(servers) != 3 || servers[0].Id != "game_1" {
+		t.Fatalf("list servers %d %s", w.Code, w.Body)
+	}
+
+	if w := request(http.MethodPost, "/api/servers/drain", `{"id":"hall_1"}`); w.Code != http.StatusOK || !r.servers["hall_1"].draining {
+		t.Errorf("drain server %d %s", w.Code, w.Body)
+	}
+	if w := request(http.MethodPost, "/api/servers/drain", `{"id":"hall_1","cancel":true}`); w.Code != http.StatusOK || r.servers["hall_1"].draining {
+		t.Errorf("cancel drain server %d %s", w.Code, w.Body)
+	}
+	out := r.servers["hall_2"].out.(*testConn)
+	if w := request(http.MethodPost, "/api/servers/kick", `{"id":"hall_2"}`); w.Code != http.StatusOK || !out.isClose {
+		t.Errorf("kick server %d %s", w.Code, w.Body)
+	}
+	if w := request(http.MethodPost, "/api/servers/unregister", `{"id":"game_1"}`); w.Code != http.StatusOK || r.servers["game_1"] != nil {
+		t.Errorf("unregister server %d %s", w.Code, w.Body)
+	}
+	for _, path := range []string{"/api/servers/drain", "/api/servers/kick", "/api/servers/unregister"} {
+		if w := request(http.MethodPost, path, `{"id":"shop_1"}`); w.Code == http.StatusOK {
+			t.Errorf("%s unknown server %s", path, w.Body)
+		}
+	}
+
+	// 服务不可通过路由的消息调用管理接口
+	if err := r.set.Handle(&cmd.Context{Out: &testConn{}}, "func_adminDrain", []byte(`{"id":"hall_1"}`)); err == nil || r.servers["hall_1"].draining {
+		t.Errorf("handle admin message %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package router
+
+// 灰度路由规则，通过内部消息c2s_setRouteRules修改后同步到所有网关
+
+import (
+	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/log"
+)
+
+type routeRulesArgs struct {
+	Rules []cmd.RouteRule `json:"rules" binding:"dive"`
+}
+
+// 灰度服务仅接收规则选中的会话
+func (r *Router) isCanaryServer(server *Server) bool {
+	for _, rule := range r.routeRules {
+		if server.hasName(rule.Name) && rule.MatchLabels(server.labels) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Router) syncRouteRules(gw *Server) {
+	gw.out.WriteJSON("s2c_routeRules", cmd.M{"rules": r.routeRules})
+}
+
+func (r *Router) c2sSetRouteRules(ctx *cmd.Context, data any) {
+	args := data.(*routeRulesArgs)
+	r.routeRules = args.Rules
+	log.Infof("set route rules %+v", r.routeRules)
+	for _, server := range r.servers {
+		if server.IsGateway() {
+			r.syncRouteRules(server)
+		}
+	}
+}
+
+func (r *Router) c2sGetRouteRules(ctx *cmd.Context, data any) {
+	ctx.Out.WriteJSON("s2c_getRouteRules", cmd.M{"rules": r.routeRules})
+}
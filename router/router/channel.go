@@ -0,0 +1,77 @@
+package router
+
+// 频道订阅。网关上报有会话订阅的频道，发布的消息仅转发到有订阅的网关
+
+import (
+	"encoding/json"
+
+	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/log"
+)
+
+type channelArgs struct {
+	Channel  string          `json:"channel,omitempty"`
+	MsgId    string          `json:"msgId,omitempty"`
+	MsgData  json.RawMessage `json:"msgData,omitempty"`
+	Channels []string        `json:"channels,omitempty"`
+}
+
+func (r *Router) subscribe(channel, gatewayId string) {
+	if _, ok := r.channelGateways[channel]; !ok {
+		r.channelGateways[channel] = map[string]bool{}
+	}
+	r.channelGateways[channel][gatewayId] = true
+}
+
+func (r *Router) unsubscribe(channel, gatewayId string) {
+	if gateways, ok := r.channelGateways[channel]; ok {
+		delete(gateways, gatewayId)
+		if len(gateways) == 0 {
+			delete(r.channelGateways, channel)
+		}
+	}
+}
+
+// 网关断开后移除网关订阅的所有频道
+func (r *Router) removeGatewayChannels(gatewayId string) {
+	for channel := range r.channelGateways {
+		r.unsubscribe(channel, gatewayId)
+	}
+}
+
+func (r *Router) c2sSubscribe(ctx *cmd.Context, data any) {
+	args := data.(*channelArgs)
+	if gateway := r.findServerByConn(ctx.Out); gateway != nil {
+		r.subscribe(args.Channel, gateway.id)
+	}
+}
+
+func (r *Router) c2sUnsubscribe(ctx *cmd.Context, data any) {
+	args := data.(*channelArgs)
+	if gateway := r.findServerByConn(ctx.Out); gateway != nil {
+		r.unsubscribe(args.Channel, gateway.id)
+	}
+}
+
+// 网关注册后同步订阅的频道
+func (r *Router) c2sSyncChannels(ctx *cmd.Context, data any) {
+	args := data.(*channelArgs)
+	gateway := r.findServerByConn(ctx.Out)
+	if gateway == nil {
+		return
+	}
+	log.Infof("gateway %s sync %d channels", gateway.id, len(args.Channels))
+	r.removeGatewayChannels(gateway.id)
+	for _, channel := range args.Channels {
+		r.subscribe(channel, gateway.id)
+	}
+}
+
+func (r *Router) c2sPublish(ctx *cmd.Context, data any) {
+	args := data.(*channelArgs)
+	for gatewayId := range r.channelGateways[args.Channel] {
+		if gateway, ok := r.servers[gatewayId]; ok {
+			gateway.out.WriteJSON("func_publish", cmd.M{"channel": args.Channel, "id": args.MsgId, "data": args.MsgData})
+		}
+	}
+}
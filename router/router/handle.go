@@ -0,0 +1,230 @@
+package router
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/log"
+)
+
+type routeArgs struct {
+	cmd.ServiceConfig
+
+	Weight int `json:"weight,omitempty"`
+}
+
+type forwardArgs struct {
+	ServerId   string          `json:"serverId,omitempty"`
+	ServerName string          `json:"serverName,omitempty"`
+	MsgId      string          `json:"msgId,omitempty"`
+	MsgData    json.RawMessage `json:"msgData,omitempty"`
+	Seq        uint64          `json:"seq,omitempty"`     // 可靠消息
+	Sender     string          `json:"sender,omitempty"`  // 可靠消息的发送方
+	ReplyTo    string          `json:"replyTo,omitempty"` // 可靠消息回复的服务ID
+	Acked      uint64          `json:"acked,omitempty"`   // 可靠消息发送方已确认的序号
+	Ssid       string          `json:"ssid,omitempty"`    // 消息所属的会话，如网关迁移的会话
+}
+
+func (r *Router) bind() {
+	s := r.set
+	s.Bind("c2s_register", r.locked(r.c2sRegister), (*routeArgs)(nil), cmd.WithPrivate())
+	s.Bind("c2s_getServerAddr", r.locked(r.c2sGetServerAddr), (*routeArgs)(nil), cmd.WithPrivate())
+	s.Bind("c2s_concurrent", r.locked(r.c2sConcurrent), (*routeArgs)(nil), cmd.WithPrivate())
+	s.Bind("c2s_route", r.locked(r.c2sRoute), (*forwardArgs)(nil), cmd.WithPrivate())
+	s.Bind("c2s_queryServerState", r.locked(r.c2sQueryServerState), (*routeArgs)(nil), cmd.WithPrivate())
+	s.Bind("c2s_getBestGateway", r.locked(r.c2sGetBestGateway), (*routeArgs)(nil), cmd.WithPrivate())
+	s.Bind("c2s_reportHealth", r.locked(r.c2sReportHealth), (*cmd.ServiceHealth)(nil), cmd.WithPrivate())
+	s.Bind("c2s_broadcast", r.locked(r.c2sBroadcast), (*cmd.Package)(nil), cmd.WithPrivate())
+	s.Bind("func_close", r.locked(r.funcClose), (*routeArgs)(nil), cmd.WithPrivate())
+
+	s.Bind("c2s_bindUser", r.locked(r.c2sBindUser), (*userArgs)(nil), cmd.WithPrivate())
+	s.Bind("c2s_unbindUser", r.locked(r.c2sUnbindUser), (*userArgs)(nil), cmd.WithPrivate())
+	s.Bind("c2s_syncUsers", r.locked(r.c2sSyncUsers), (*userArgs)(nil), cmd.WithPrivate())
+	s.Bind("c2s_pushToUsers", r.locked(r.c2sPushToUsers), (*userArgs)(nil), cmd.WithPrivate())
+
+	s.Bind("c2s_subscribe", r.locked(r.c2sSubscribe), (*channelArgs)(nil), cmd.WithPrivate())
+	s.Bind("c2s_unsubscribe", r.locked(r.c2sUnsubscribe), (*channelArgs)(nil), cmd.WithPrivate())
+	s.Bind("c2s_syncChannels", r.locked(r.c2sSyncChannels), (*channelArgs)(nil), cmd.WithPrivate())
+	s.Bind("c2s_publish", r.locked(r.c2sPublish), (*channelArgs)(nil), cmd.WithPrivate())
+
+	s.Bind("c2s_setRouteRules", r.locked(r.c2sSetRouteRules), (*routeRulesArgs)(nil), cmd.WithPrivate())
+	s.Bind("c2s_getRouteRules", r.locked(r.c2sGetRouteRules), (*routeRulesArgs)(nil), cmd.WithPrivate())
+
+	s.BindSchema()
+}
+
+// 消息在连接的协程中处理时可能并发，加锁访问服务列表等状态
+func (r *Router) locked(h cmd.Handler) cmd.Handler {
+	return func(ctx *cmd.Context, data any) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		h(ctx, data)
+	}
+}
+
+// 定时器中加锁执行
+func (r *Router) lockedFunc(f func()) func() {
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		f()
+	}
+}
+
+// ServerAddr == "" 无服务
+func (r *Router) c2sRegister(ctx *cmd.Context, data any) {
+	args := data.(*routeArgs)
+	host, port, _ := net.SplitHostPort(args.Addr)
+	if host == "" {
+		host, _, _ = net.SplitHostPort(ctx.Out.RemoteAddr())
+	}
+
+	var addr string
+	if port != "" {
+		addr = host + ":" + port
+	}
+	log.Infof("register server:%s %s addr:%s labels:%v", args.Id, args.Name, addr, args.Labels)
+
+	newServer := &Server{
+		out:        ctx.Out,
+		id:         args.Id,
+		name:       args.Name,
+		addr:       addr,
+		minWeight:  args.MinWeight,
+		maxWeight:  args.MaxWeight,
+		labels:     args.Labels,
+		reportTime: time.Now(),
+	}
+	newServer.connectTime = newServer.reportTime
+	// ID冲突时断开新的连接，服务重连后再注册
+	if !r.addServer(newServer) {
+		newServer.out.Close()
+		return
+	}
+	if newServer.IsGateway() {
+		newServer.out.WriteJSON("s2c_queryUsers", struct{}{})
+		newServer.out.WriteJSON("s2c_queryChannels", struct{}{})
+		r.syncRouteRules(newServer)
+	}
+
+	for _, server := range r.servers {
+		if server.IsGateway() {
+			server.out.WriteJSON("s2c_register", struct{}{})
+		}
+	}
+}
+
+func (r *Router) c2sGetServerAddr(ctx *cmd.Context, data any) {
+	args := data.(*routeArgs)
+	name := args.Name
+	addr := r.matchBestServer(name)
+	log.Infof("get server:%s addr:%s", name, addr)
+	ctx.Out.WriteJSON("s2c_getServerAddr", cmd.M{"name": name, "addr": addr})
+}
+
+func (r *Router) c2sBroadcast(ctx *cmd.Context, data any) {
+	pkg := data.(*cmd.Package)
+	for _, server := range r.servers {
+		if server.IsGateway() {
+			server.out.WriteJSON("func_broadcast", pkg)
+		}
+	}
+}
+
+// 更新网关负载
+func (r *Router) c2sConcurrent(ctx *cmd.Context, data any) {
+	args := data.(*routeArgs)
+
+	server := r.findServerByConn(ctx.Out)
+	if server == nil {
+		return
+	}
+	log.Debugf("concurrent %v %v", server.id, args.Weight)
+
+	server.weight = args.Weight
+	server.reportTime = time.Now()
+}
+
+func (r *Router) c2sRoute(ctx *cmd.Context, data any) {
+	args := data.(*forwardArgs)
+
+	var matchServers []string
+	for id := range r.servers {
+		if args.ServerName == "*" || args.ServerName == r.servers[id].name {
+			matchServers = append(matchServers, id)
+		}
+	}
+	if args.ServerId != "" {
+		if _, ok := r.servers[args.ServerId]; ok {
+			matchServers = []string{args.ServerId}
+		}
+	}
+
+	// 可靠消息由接收方通过路由回复发送方，会话的消息保留会话ID
+	var pkgMsg []byte
+	if args.Seq > 0 || args.Ssid != "" {
+		pkg := &cmd.Package{Id: args.MsgId, Data: args.MsgData, Ssid: args.Ssid, Seq: args.Seq, Sender: args.Sender, ReplyTo: args.ReplyTo, Acked: args.Acked}
+		pkgMsg, _ = cmd.EncodePackage(pkg)
+	}
+	for _, id := range matchServers {
+		if server, ok := r.servers[id]; ok {
+			if pkgMsg != nil {
+				server.out.Write(pkgMsg)
+			} else {
+				server.out.WriteJSON(args.MsgId, args.MsgData)
+			}
+		}
+	}
+}
+
+func (r *Router) funcClose(ctx *cmd.Context, data any) {
+	// args := data.(*Args)
+	closedServer := r.findServerByConn(ctx.Out)
+	if closedServer == nil {
+		return
+	}
+	log.Infof("server %s lose connection", closedServer.id)
+	r.unregisterServer(closedServer)
+}
+
+// 移除服务及网关上的会话
+func (r *Router) unregisterServer(server *Server) {
+	if r.servers[server.id] == server {
+		delete(r.servers, server.id)
+	}
+	if server.IsGateway() {
+		r.removeGatewayUsers(server.id)
+		r.removeGatewayChannels(server.id)
+	}
+}
+
+func (r *Router) queryServerStates() []serverState {
+	var states []serverState
+	for _, server := range r.servers {
+		states = append(states, serverState{
+			Id:        server.id,
+			Name:      server.name,
+			Weight:    server.weight,
+			MinWeight: server.minWeight,
+			MaxWeight: server.maxWeight,
+			Unhealthy: server.unhealthy,
+			Draining:  server.draining,
+			Labels:    server.labels,
+		})
+		// log.Debug("query server state", server.id, server.weight)
+	}
+	return states
+}
+
+// 同步服务状态，需主动查询
+func (r *Router) c2sQueryServerState(ctx *cmd.Context, data any) {
+	states := r.queryServerStates()
+	ctx.Out.WriteJSON("s2c_queryServerState", cmd.M{"servers": states})
+}
+
+func (r *Router) c2sGetBestGateway(ctx *cmd.Context, data any) {
+	addr := r.matchBestGateway()
+	ctx.Out.WriteJSON("s2c_getBestGateway", cmd.M{"addr": addr})
+}
@@ -0,0 +1,62 @@
+package router
+
+// 服务健康检查
+// 定时向服务发送s2c_healthCheck，服务回复c2s_reportHealth上报状态及负载
+// 超过healthTimeout未上报的服务标记为不健康，不再分配新的请求
+
+import (
+	"time"
+
+	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/log"
+)
+
+const (
+	healthCheckPeriod = 5 * time.Second
+	healthTimeout     = 3 * healthCheckPeriod
+)
+
+func (r *Router) setHealthy(server *Server, healthy bool) {
+	if server.unhealthy == !healthy {
+		return
+	}
+	server.unhealthy = !healthy
+	log.Infof("server %s healthy %v", server.id, healthy)
+	r.syncServerStates()
+}
+
+// 通知网关更新服务状态
+func (r *Router) syncServerStates() {
+	states := r.queryServerStates()
+	for _, gw := range r.servers {
+		if gw.IsGateway() {
+			gw.out.WriteJSON("s2c_queryServerState", cmd.M{"servers": states})
+		}
+	}
+}
+
+func (r *Router) checkHealth() {
+	now := time.Now()
+	for _, server := range r.servers {
+		// 恢复的服务等待重新注册
+		if server.restored {
+			continue
+		}
+		if now.Sub(server.reportTime) > healthTimeout {
+			r.setHealthy(server, false)
+		}
+		server.out.WriteJSON("s2c_healthCheck", struct{}{})
+	}
+}
+
+// 服务上报健康状态及负载
+func (r *Router) c2sReportHealth(ctx *cmd.Context, data any) {
+	args := data.(*cmd.ServiceHealth)
+	server := r.findServerByConn(ctx.Out)
+	if server == nil {
+		return
+	}
+	server.weight = args.Weight
+	server.reportTime = time.Now()
+	r.setHealthy(server, !args.Unhealthy)
+}
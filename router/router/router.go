@@ -0,0 +1,247 @@
+package router
+
+// 路由。服务注册、服务发现及消息转发
+// 可嵌入其他进程，如测试时在同一进程中启动路由、网关及业务服
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/log"
+	"github.com/guogeer/quasar/v2/utils"
+)
+
+const defaultReconcileWindow = 30 * time.Second
+
+type Options struct {
+	CmdSet          *cmd.CmdSet   // 处理服务的消息。为空时新建
+	Snapshot        string        // 服务列表快照文件。为空时不开启
+	ReconcileWindow time.Duration // 恢复的服务重新注册的等待时间。默认30s
+}
+
+type Router struct {
+	opts Options
+	set  *cmd.CmdSet
+
+	// 以下状态通过mu访问
+	mu              sync.Mutex
+	servers         map[string]*Server
+	userSessions    map[string]map[string]string // 用户的会话。[userId:[ssid:gatewayId]]
+	channelGateways map[string]map[string]bool   // 频道订阅的网关。[channel:[gatewayId]]
+	routeRules      []cmd.RouteRule              // 灰度规则
+	snapshotBytes   []byte                       // 最近保存的快照，未变化时不写文件
+
+	startOnce sync.Once
+}
+
+type Server struct {
+	out cmd.Conn
+
+	id   string
+	name string // 服务。若存在多个采用逗号,隔开
+	addr string // 地址
+
+	labels map[string]string // 服务的标签，如version
+
+	minWeight int // 最小负载
+	maxWeight int // 最大负载
+	weight    int // 当前负载
+
+	unhealthy   bool      // 健康检查失败或服务上报不可用
+	draining    bool      // 管理后台下线，不再分配新的请求
+	reportTime  time.Time // 最近上报健康状态的时间
+	connectTime time.Time // 注册的时间
+	restored    bool      // 从快照恢复，未重新注册
+}
+
+func New(opts Options) *Router {
+	if opts.ReconcileWindow <= 0 {
+		opts.ReconcileWindow = defaultReconcileWindow
+	}
+	set := opts.CmdSet
+	if set == nil {
+		set = cmd.NewCmdSet()
+	}
+	r := &Router{
+		opts:            opts,
+		set:             set,
+		servers:         map[string]*Server{},
+		userSessions:    map[string]map[string]string{},
+		channelGateways: map[string]map[string]bool{},
+	}
+	r.bind()
+	return r
+}
+
+// 路由处理消息的CmdSet
+func (r *Router) CmdSet() *cmd.CmdSet {
+	return r.set
+}
+
+// 加载快照并开启健康检查，需在主循环中执行utils.GetTimerSet().RunOnce()
+func (r *Router) Start() {
+	r.startOnce.Do(func() {
+		if path := r.opts.Snapshot; path != "" {
+			r.mu.Lock()
+			err := r.loadSnapshot(path)
+			r.mu.Unlock()
+			if err != nil {
+				log.Errorf("load snapshot %s %v", path, err)
+			}
+			utils.NewTimer(r.lockedFunc(r.reconcileServers), r.opts.ReconcileWindow)
+			utils.NewPeriodTimer(r.lockedFunc(r.saveSnapshot), time.Now(), time.Second)
+		}
+		utils.NewPeriodTimer(r.lockedFunc(r.checkHealth), time.Now(), healthCheckPeriod)
+	})
+}
+
+// 处理服务的连接
+func (r *Router) Serve(l net.Listener) error {
+	srv := &cmd.Server{CmdSet: r.set}
+	return srv.Serve(l)
+}
+
+func (r *Router) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return r.Serve(l)
+}
+
+func (server *Server) IsGateway() bool {
+	return server.name == "gateway"
+}
+
+// 负载比例。未设置最大负载时为当前负载
+func (server *Server) load() float64 {
+	if server.maxWeight > 0 {
+		return float64(server.weight) / float64(server.maxWeight)
+	}
+	return float64(server.weight)
+}
+
+// 可分配新的请求
+func (server *Server) isAvailable() bool {
+	return !server.unhealthy && !server.draining
+}
+
+func (server *Server) isFull() bool {
+	return server.maxWeight > 0 && server.weight >= server.maxWeight
+}
+
+func (server *Server) hasName(name string) bool {
+	for _, serverName := range strings.Split(server.name, ",") {
+		if serverName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 从候选服务中选择负载最低的
+// 1、优先选择可用且未满载的服务，都不满足时选择可用的服务，无可用的服务时返回空
+// 2、负载相同，选择ID更小
+func matchLowestLoad(candidates []*Server) *Server {
+	var matchServer *Server
+	for _, checkFull := range []bool{true, false} {
+		for _, server := range candidates {
+			if !server.isAvailable() || (checkFull && server.isFull()) {
+				continue
+			}
+			if matchServer == nil || server.load() < matchServer.load() ||
+				(server.load() == matchServer.load() && server.id < matchServer.id) {
+				matchServer = server
+			}
+		}
+		if matchServer != nil {
+			break
+		}
+	}
+	return matchServer
+}
+
+// 匹配最佳gw地址
+func (r *Router) matchBestGateway() string {
+	var candidates []*Server
+	for _, server := range r.servers {
+		if server.IsGateway() {
+			candidates = append(candidates, server)
+		}
+	}
+	if server := matchLowestLoad(candidates); server != nil {
+		return server.addr
+	}
+	return ""
+}
+
+// 匹配服务。name为服务ID时直接选中，否则在同名的服务中按负载选择
+func (r *Router) matchBestServer(name string) string {
+	if server, ok := r.servers[name]; ok {
+		return server.addr
+	}
+	var candidates, stableServers []*Server
+	for _, server := range r.servers {
+		if server.hasName(name) {
+			candidates = append(candidates, server)
+			if !r.isCanaryServer(server) {
+				stableServers = append(stableServers, server)
+			}
+		}
+	}
+	// 优先选择非灰度的服务
+	if server := matchLowestLoad(stableServers); server != nil {
+		return server.addr
+	}
+	if server := matchLowestLoad(candidates); server != nil {
+		return server.addr
+	}
+	if len(candidates) > 0 {
+		log.Warnf("server %s has no available instance", name)
+	}
+	return ""
+}
+
+// 查找链接的服务
+func (r *Router) findServerByConn(out cmd.Conn) *Server {
+	for _, server := range r.servers {
+		if server.out == out {
+			return server
+		}
+	}
+	return nil
+}
+
+// 增加新服。ID已存在时仅替换快照恢复的、同一连接的或超时未上报的服务，保留下线状态
+// 旧的连接仍在上报时为ID冲突，不增加新服，返回false
+func (r *Router) addServer(server *Server) bool {
+	if old, ok := r.servers[server.id]; ok {
+		if old.out != server.out && !old.restored {
+			if time.Since(old.reportTime) <= healthTimeout {
+				log.Errorf("server %s register conflict, %s is alive", server.id, old.addr)
+				return false
+			}
+			log.Warnf("server %s register again, replace the stale connection", server.id)
+			r.unregisterServer(old)
+			old.out.Close()
+		}
+		server.draining = old.draining
+	}
+	r.servers[server.id] = server
+	return true
+}
+
+type serverState struct {
+	MinWeight int    `json:"minWeight,omitempty"`
+	MaxWeight int    `json:"maxWeight,omitempty"`
+	Weight    int    `json:"weight,omitempty"`
+	Id        string `json:"id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Unhealthy bool   `json:"unhealthy,omitempty"`
+	Draining  bool   `json:"draining,omitempty"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+}
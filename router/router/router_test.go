@@ -0,0 +1,84 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/guogeer/quasar/v2/cmd"
+)
+
+func newCanaryRouter() *Router {
+	r := New(Options{})
+	r.servers = map[string]*Server{
+		"hall_1": {id: "hall_1", name: "hall", addr: "127.0.0.1:1001", weight: 5},
+		"hall_2": {id: "hall_2", name: "hall", addr: "127.0.0.1:1002", weight: 1, labels: map[string]string{"version": "2"}},
+		"game_1": {id: "game_1", name: "game,hall2", addr: "127.0.0.1:2001", labels: map[string]string{"version": "2"}},
+	}
+	r.routeRules = []cmd.RouteRule{{Name: "hall", Labels: map[string]string{"version": "2"}, Percent: 100}}
+	return r
+}
+
+func TestIsCanaryServer(t *testing.T) {
+	r := newCanaryRouter()
+	for id, expect := range map[string]bool{"hall_1": false, "hall_2": true, "game_1": false} {
+		if isCanary := r.isCanaryServer(r.servers[id]); isCanary != expect {
+			t.Errorf("server %s canary %v", id, isCanary)
+		}
+	}
+}
+
+func TestRouterMatchBestServer(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(r *Router)
+		server string
+		expect string
+	}{
+		{"stable", nil, "hall", "127.0.0.1:1001"},
+		{"server id", nil, "hall_2", "127.0.0.1:1002"},
+		{"stable unhealthy", func(r *Router) { r.servers["hall_1"].unhealthy = true }, "hall", "127.0.0.1:1002"},
+		{"stable draining", func(r *Router) { r.servers["hall_1"].draining = true }, "hall", "127.0.0.1:1002"},
+		{"no rules", func(r *Router) { r.routeRules = nil }, "hall", "127.0.0.1:1002"},
+		{"multiple names", nil, "hall2", "127.0.0.1:2001"},
+		{"unknown", nil, "shop", ""},
+	}
+	for _, tt := range tests {
+		r := newCanaryRouter()
+		if tt.setup != nil {
+			tt.setup(r)
+		}
+		if addr := r.matchBestServer(tt.server); addr != tt.expect {
+			t.Errorf("%s match server %s", tt.name, addr)
+		}
+	}
+}
+
+func TestAddServer(t *testing.T) {
+	r := newAdminRouter()
+	old := r.servers["hall_1"]
+	old.reportTime = time.Now()
+	old.draining = true
+
+	// 旧的连接仍在上报时拒绝
+	conflict := &Server{id: "hall_1", out: &testConn{}}
+	if r.addServer(conflict) || r.servers["hall_1"] != old || old.out.(*testConn).isClose {
+		t.Error("replace alive server")
+	}
+	// 同一连接重新注册时替换
+	same := &Server{id: "hall_1", out: old.out}
+	if !r.addServer(same) || r.servers["hall_1"] != same || !same.draining || old.out.(*testConn).isClose {
+		t.Error("register again on the same connection")
+	}
+	// 超时未上报时替换并关闭旧的连接
+	same.reportTime = time.Now().Add(-2 * healthTimeout)
+	stale := &Server{id: "hall_1", out: &testConn{}}
+	if !r.addServer(stale) || r.servers["hall_1"] != stale || !stale.draining || !same.out.(*testConn).isClose {
+		t.Error("replace stale server")
+	}
+	// 快照恢复的服务直接替换
+	r.servers["hall_2"].restored = true
+	restored := &Server{id: "hall_2", out: &testConn{}}
+	if !r.addServer(restored) || r.servers["hall_2"] != restored {
+		t.Error("replace restored server")
+	}
+}
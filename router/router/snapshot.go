@@ -0,0 +1,133 @@
+package router
+
+// 服务列表快照。路由重启后加载快照恢复服务列表，网关及业务服无需等待服务重新注册
+// 恢复的服务在对账期内可正常分配请求，期间未重新注册的服务将被移除
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/log"
+)
+
+type serverSnapshot struct {
+	Id          string            `json:"id"`
+	Name        string            `json:"name"`
+	Addr        string            `json:"addr,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	MinWeight   int               `json:"minWeight,omitempty"`
+	MaxWeight   int               `json:"maxWeight,omitempty"`
+	Draining    bool              `json:"draining,omitempty"`
+	ConnectTime time.Time         `json:"connectTime"`
+}
+
+type registrySnapshot struct {
+	Servers    []serverSnapshot `json:"servers"`
+	RouteRules []cmd.RouteRule  `json:"routeRules,omitempty"`
+}
+
+// 恢复的服务未建立连接，忽略发送的消息
+type offlineConn struct{}
+
+func (c *offlineConn) Write([]byte) error                 { return nil }
+func (c *offlineConn) WriteJSON(name string, i any) error { return nil }
+func (c *offlineConn) RemoteAddr() string                 { return "" }
+func (c *offlineConn) Close()                             {}
+
+func (r *Router) takeSnapshot() *registrySnapshot {
+	snapshot := &registrySnapshot{Servers: []serverSnapshot{}, RouteRules: r.routeRules}
+	for _, server := range r.servers {
+		snapshot.Servers = append(snapshot.Servers, serverSnapshot{
+			Id:          server.id,
+			Name:        server.name,
+			Addr:        server.addr,
+			Labels:      server.labels,
+			MinWeight:   server.minWeight,
+			MaxWeight:   server.maxWeight,
+			Draining:    server.draining,
+			ConnectTime: server.connectTime,
+		})
+	}
+	sort.Slice(snapshot.Servers, func(i, j int) bool { return snapshot.Servers[i].Id < snapshot.Servers[j].Id })
+	return snapshot
+}
+
+// 写临时文件后重命名，避免进程退出时文件不完整
+func (r *Router) saveSnapshot() {
+	snapshotPath := r.opts.Snapshot
+	buf, err := json.MarshalIndent(r.takeSnapshot(), "", "  ")
+	if err != nil {
+		log.Errorf("encode snapshot %v", err)
+		return
+	}
+	if bytes.Equal(buf, r.snapshotBytes) {
+		return
+	}
+	tmpPath := filepath.Join(filepath.Dir(snapshotPath), "."+filepath.Base(snapshotPath)+".tmp")
+	if err := os.WriteFile(tmpPath, buf, 0o644); err != nil {
+		log.Errorf("save snapshot %v", err)
+		return
+	}
+	if err := os.Rename(tmpPath, snapshotPath); err != nil {
+		log.Errorf("save snapshot %v", err)
+		return
+	}
+	r.snapshotBytes = buf
+}
+
+// 加载快照，恢复的服务在对账期后未重新注册时移除
+func (r *Router) loadSnapshot(path string) error {
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	snapshot := &registrySnapshot{}
+	if err := json.Unmarshal(buf, snapshot); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, s := range snapshot.Servers {
+		r.servers[s.Id] = &Server{
+			out:         &offlineConn{},
+			id:          s.Id,
+			name:        s.Name,
+			addr:        s.Addr,
+			labels:      s.Labels,
+			minWeight:   s.MinWeight,
+			maxWeight:   s.MaxWeight,
+			draining:    s.Draining,
+			connectTime: s.ConnectTime,
+			reportTime:  now,
+			restored:    true,
+		}
+	}
+	r.routeRules = snapshot.RouteRules
+	r.snapshotBytes = buf
+	log.Infof("load snapshot %s servers %d, reconcile in %v", path, len(snapshot.Servers), r.opts.ReconcileWindow)
+	return nil
+}
+
+// 对账期结束，移除未重新注册的服务
+func (r *Router) reconcileServers() {
+	var isChanged bool
+	for _, server := range r.servers {
+		if server.restored {
+			log.Infof("server %s not register again, remove", server.id)
+			r.unregisterServer(server)
+			isChanged = true
+		}
+	}
+	if isChanged {
+		r.syncServerStates()
+	}
+}
@@ -0,0 +1,53 @@
+package router
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	r := New(Options{Snapshot: path})
+	r.servers = newAdminRouter().servers
+	r.servers["hall_1"].draining = true
+	r.routeRules = newCanaryRouter().routeRules
+	r.servers["hall_1"].weight = 8 // 负载不保存
+	r.saveSnapshot()
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 未变化时不写文件
+	r.saveSnapshot()
+	if stat2, _ := os.Stat(path); !stat2.ModTime().Equal(stat.ModTime()) {
+		t.Error("save unchanged snapshot")
+	}
+
+	r2 := New(Options{Snapshot: path})
+	if err := r2.loadSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	hall := r2.servers["hall_1"]
+	if len(r2.servers) != 3 || hall == nil || !hall.restored || !hall.draining || hall.weight != 0 || hall.addr != "127.0.0.1:1001" {
+		t.Fatalf("load snapshot servers %d %+v", len(r2.servers), hall)
+	}
+	if len(r2.routeRules) != 1 || !r2.isCanaryServer(r2.servers["hall_2"]) {
+		t.Errorf("load snapshot rules %+v", r2.routeRules)
+	}
+
+	// 对账期后移除未重新注册的服务
+	r2.servers["hall_2"].restored = false
+	r2.reconcileServers()
+	if len(r2.servers) != 1 || r2.servers["hall_2"] == nil {
+		t.Errorf("reconcile servers %d", len(r2.servers))
+	}
+
+	if err := r2.loadSnapshot(filepath.Join(t.TempDir(), "none.json")); err != nil {
+		t.Errorf("load missing snapshot %v", err)
+	}
+	os.WriteFile(path, []byte("{"), 0o644)
+	if err := r2.loadSnapshot(path); err == nil {
+		t.Error("load invalid snapshot")
+	}
+}
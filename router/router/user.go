@@ -0,0 +1,104 @@
+package router
+
+// 用户在线目录。网关上报认证后的用户所在的会话，业务服可按用户ID推送消息
+
+import (
+	"encoding/json"
+
+	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/log"
+)
+
+type userArgs struct {
+	UserId  string          `json:"userId,omitempty"`
+	Ssid    string          `json:"ssid,omitempty"`
+	UserIds []string        `json:"userIds,omitempty"`
+	MsgId   string          `json:"msgId,omitempty"`
+	MsgData json.RawMessage `json:"msgData,omitempty"`
+
+	Users []userSession `json:"users,omitempty"`
+}
+
+type userSession struct {
+	UserId string `json:"userId,omitempty"`
+	Ssid   string `json:"ssid,omitempty"`
+}
+
+func (r *Router) bindUser(userId, ssid, gatewayId string) {
+	if userId == "" || ssid == "" {
+		return
+	}
+	if _, ok := r.userSessions[userId]; !ok {
+		r.userSessions[userId] = map[string]string{}
+	}
+	r.userSessions[userId][ssid] = gatewayId
+}
+
+// 仅解绑会话所在的网关，避免会话迁移后误删
+func (r *Router) unbindUser(userId, ssid, gatewayId string) {
+	if sessions, ok := r.userSessions[userId]; ok && sessions[ssid] == gatewayId {
+		delete(sessions, ssid)
+		if len(sessions) == 0 {
+			delete(r.userSessions, userId)
+		}
+	}
+}
+
+// 网关断开后移除网关上的所有会话
+func (r *Router) removeGatewayUsers(gatewayId string) {
+	for userId, sessions := range r.userSessions {
+		for ssid, id := range sessions {
+			if id == gatewayId {
+				delete(sessions, ssid)
+			}
+		}
+		if len(sessions) == 0 {
+			delete(r.userSessions, userId)
+		}
+	}
+}
+
+func (r *Router) c2sBindUser(ctx *cmd.Context, data any) {
+	args := data.(*userArgs)
+	if gateway := r.findServerByConn(ctx.Out); gateway != nil {
+		r.bindUser(args.UserId, args.Ssid, gateway.id)
+	}
+}
+
+func (r *Router) c2sUnbindUser(ctx *cmd.Context, data any) {
+	args := data.(*userArgs)
+	if gateway := r.findServerByConn(ctx.Out); gateway != nil {
+		r.unbindUser(args.UserId, args.Ssid, gateway.id)
+	}
+}
+
+// 网关注册后同步全部在线用户
+func (r *Router) c2sSyncUsers(ctx *cmd.Context, data any) {
+	args := data.(*userArgs)
+	gateway := r.findServerByConn(ctx.Out)
+	if gateway == nil {
+		return
+	}
+	log.Infof("gateway %s sync %d users", gateway.id, len(args.Users))
+	r.removeGatewayUsers(gateway.id)
+	for _, user := range args.Users {
+		r.bindUser(user.UserId, user.Ssid, gateway.id)
+	}
+}
+
+// 按网关合并后推送
+func (r *Router) c2sPushToUsers(ctx *cmd.Context, data any) {
+	args := data.(*userArgs)
+
+	gatewaySessions := map[string][]string{}
+	for _, userId := range args.UserIds {
+		for ssid, gatewayId := range r.userSessions[userId] {
+			gatewaySessions[gatewayId] = append(gatewaySessions[gatewayId], ssid)
+		}
+	}
+	for gatewayId, ssids := range gatewaySessions {
+		if gateway, ok := r.servers[gatewayId]; ok {
+			gateway.out.WriteJSON("func_push", cmd.M{"ssids": ssids, "id": args.MsgId, "data": args.MsgData})
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/guogeer/quasar/v2/cmd"
+)
+
+// 生成Unity可用的C#代码，字段兼容JsonUtility
+type csGenerator struct {
+	namespace string
+
+	classes []string // 匿名结构体生成的类
+}
+
+func (g *csGenerator) typeName(schema *cmd.JSONSchema, anonName string) string {
+	if schema == nil {
+		return "object"
+	}
+	if schema.Ref != "" {
+		return strings.TrimPrefix(schema.Ref, "#/$defs/")
+	}
+	switch schema.Type {
+	case "boolean":
+		return "bool"
+	case "integer":
+		return "long"
+	case "number":
+		return "double"
+	case "string":
+		return "string"
+	case "array":
+		return "List<" + g.typeName(schema.Items, anonName+"Item") + ">"
+	case "object":
+		if schema.Properties == nil {
+			return "Dictionary<string, " + g.typeName(schema.AdditionalProperties, anonName+"Value") + ">"
+		}
+		g.classes = append(g.classes, g.class(anonName, schema))
+		return anonName
+	}
+	return "object"
+}
+
+func (g *csGenerator) class(name string, schema *cmd.JSONSchema) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "    [Serializable]\n    public class %s\n    {\n", name)
+	for _, field := range propertyNames(schema) {
+		fieldType := g.typeName(schema.Properties[field], name+pascalCase(field))
+		fmt.Fprintf(&b, "        public %s %s;\n", fieldType, field)
+	}
+	b.WriteString("    }\n")
+	return b.String()
+}
+
+func (g *csGenerator) Generate(w io.Writer, doc *cmd.SchemaDoc, routes []route) error {
+	for _, name := range sortedKeys(doc.Defs) {
+		g.classes = append(g.classes, g.class(name, doc.Defs[name]))
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "// Code generated by stubgen. DO NOT EDIT.")
+	fmt.Fprintln(bw, "using System;")
+	fmt.Fprintln(bw, "using System.Collections.Generic;")
+	fmt.Fprintln(bw)
+	fmt.Fprintf(bw, "namespace %s\n{\n", g.namespace)
+	fmt.Fprintln(bw, "    [Serializable]\n    public class Reply<T>\n    {\n        public string code;\n        public string msg;\n        public T data;\n    }")
+
+	fmt.Fprintln(bw)
+	fmt.Fprintln(bw, "    public static class MsgId\n    {")
+	for _, r := range routes {
+		fmt.Fprintf(bw, "        public const string %s = %q;\n", r.Name, r.MsgId)
+	}
+	fmt.Fprintln(bw, "    }")
+
+	// 消息参数为匿名结构体时生成类
+	for _, r := range routes {
+		g.typeName(r.Args, r.Name+"Args")
+		g.typeName(r.Reply, r.Name+"Reply")
+	}
+	for _, class := range g.classes {
+		fmt.Fprintln(bw)
+		fmt.Fprint(bw, class)
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
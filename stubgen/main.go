@@ -0,0 +1,174 @@
+// 根据协议描述生成客户端代码
+// 协议描述来自进程的cmd.SchemaHandler或消息c2s_schema，如网关的/schema、路由管理后台的/api/schema
+//
+//	stubgen -in http://127.0.0.1:9010/schema -lang ts -prefix hall -out protocol.ts
+//	stubgen -in schema.json -lang cs -namespace Game.Protocol -out Protocol.cs
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/guogeer/quasar/v2/cmd"
+	"github.com/guogeer/quasar/v2/log"
+)
+
+var (
+	in            = flag.String("in", "-", "schema file path or http url, - read stdin")
+	out           = flag.String("out", "-", "output file path, - write stdout")
+	lang          = flag.String("lang", "ts", "output language ts|cs")
+	namespace     = flag.String("namespace", "Quasar.Protocol", "C# namespace")
+	prefix        = flag.String("prefix", "", "message id prefix, such as service name hall")
+	enablePrivate = flag.Bool("private", false, "include private messages")
+)
+
+type generator interface {
+	Generate(w io.Writer, doc *cmd.SchemaDoc, routes []route) error
+}
+
+// 客户端可发送的消息
+type route struct {
+	cmd.RouteInfo
+	MsgId string // 客户端发送的消息ID
+	Name  string // 生成代码的标识符
+}
+
+func readSchema(path string) (*cmd.SchemaDoc, error) {
+	var r io.Reader = os.Stdin
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		resp, err := http.Get(path)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		r = resp.Body
+	} else if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	doc := &cmd.SchemaDoc{}
+	if err := json.NewDecoder(r).Decode(doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func filterRoutes(doc *cmd.SchemaDoc) []route {
+	var routes []route
+	for _, info := range doc.Routes {
+		if info.IsPrivate && !*enablePrivate {
+			continue
+		}
+		msgId := info.Id
+		if info.ServerName != "" {
+			msgId = info.ServerName + "." + msgId
+		} else if *prefix != "" {
+			msgId = *prefix + "." + msgId
+		}
+		routes = append(routes, route{RouteInfo: info, MsgId: msgId})
+	}
+	setRouteNames(routes)
+	return routes
+}
+
+// 标识符默认取消息ID，不同服务的同名消息加上服务名，仍重复时加序号
+func setRouteNames(routes []route) {
+	counts := map[string]int{}
+	for _, r := range routes {
+		counts[pascalCase(r.Id)]++
+	}
+	used := map[string]bool{}
+	for i := range routes {
+		r := &routes[i]
+		name := pascalCase(r.Id)
+		if counts[name] > 1 {
+			name = pascalCase(r.MsgId)
+		}
+		baseName := name
+		for k := 2; used[name]; k++ {
+			name = baseName + strconv.Itoa(k)
+		}
+		used[name] = true
+		r.Name = name
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 字段顺序优先x-order
+func propertyNames(schema *cmd.JSONSchema) []string {
+	if len(schema.Order) == len(schema.Properties) {
+		return schema.Order
+	}
+	return sortedKeys(schema.Properties)
+}
+
+// 转换成标识符。c2s_getServerAddr => C2sGetServerAddr
+func pascalCase(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, c := range s {
+		isAlnum := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !isAlnum {
+			upper = true
+			continue
+		}
+		if upper && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		upper = false
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func main() {
+	flag.Parse()
+
+	doc, err := readSchema(*in)
+	if err != nil {
+		log.Fatalf("read schema %s error %v", *in, err)
+	}
+
+	var gen generator
+	switch *lang {
+	case "ts":
+		gen = &tsGenerator{}
+	case "cs":
+		gen = &csGenerator{namespace: *namespace}
+	default:
+		log.Fatalf("unsupported language %s", *lang)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("create %s error %v", *out, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := gen.Generate(w, doc, filterRoutes(doc)); err != nil {
+		log.Fatalf("generate %s error %v", *lang, err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/guogeer/quasar/v2/cmd"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+type enterArgs struct {
+	RoomId int `json:"roomId" binding:"required"`
+}
+
+type hallReply struct {
+	Rooms []int `json:"rooms,omitempty"`
+}
+
+type roomReply struct {
+	Seats map[string]int `json:"seats,omitempty"`
+}
+
+type chatArgs struct {
+	Msg string `json:"msg,omitempty"`
+}
+
+// 不同服务的同名消息及私有消息
+func testSchema() *cmd.SchemaDoc {
+	set := cmd.NewCmdSet()
+	cmd.BindTypedTo(set, "c2s_enter", func(ctx *cmd.Context, args *enterArgs) (*hallReply, error) { return nil, nil }, cmd.WithServer("hall"))
+	cmd.BindTypedTo(set, "c2s_enter", func(ctx *cmd.Context, args *enterArgs) (*roomReply, error) { return nil, nil }, cmd.WithServer("room"))
+	cmd.BindTypedTo(set, "c2s_chat", func(ctx *cmd.Context, args *chatArgs) (*struct {
+		Ok bool `json:"ok"`
+	}, error) {
+		return nil, nil
+	})
+	set.Bind("func_sync", func(ctx *cmd.Context, data any) {}, (*chatArgs)(nil), cmd.WithPrivate())
+	return set.Schema()
+}
+
+func TestSetRouteNames(t *testing.T) {
+	routes := []route{
+		{RouteInfo: cmd.RouteInfo{Id: "c2s_enter"}, MsgId: "hall.c2s_enter"},
+		{RouteInfo: cmd.RouteInfo{Id: "c2s_enter"}, MsgId: "room.c2s_enter"},
+		{RouteInfo: cmd.RouteInfo{Id: "c2s_chat"}, MsgId: "c2s_chat"},
+		{RouteInfo: cmd.RouteInfo{Id: "c2s.chat"}, MsgId: "c2s.chat"},
+	}
+	setRouteNames(routes)
+	expects := []string{"HallC2sEnter", "RoomC2sEnter", "C2sChat", "C2sChat2"}
+	for i, r := range routes {
+		if r.Name != expects[i] {
+			t.Errorf("route %s name %s, expect %s", r.MsgId, r.Name, expects[i])
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	doc := testSchema()
+	for _, c := range []struct {
+		golden string
+		gen    generator
+	}{
+		{"protocol.ts.golden", &tsGenerator{}},
+		{"Protocol.cs.golden", &csGenerator{namespace: "Quasar.Protocol"}},
+	} {
+		var buf bytes.Buffer
+		if err := c.gen.Generate(&buf, doc, filterRoutes(doc)); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join("testdata", c.golden)
+		if *update {
+			if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expect, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), expect) {
+			t.Errorf("generate %s, run go test -update and check the diff\n%s", c.golden, buf.Bytes())
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/guogeer/quasar/v2/cmd"
+)
+
+type tsGenerator struct{}
+
+func (g *tsGenerator) typeName(schema *cmd.JSONSchema, indent string) string {
+	if schema == nil {
+		return "any"
+	}
+	if schema.Ref != "" {
+		return strings.TrimPrefix(schema.Ref, "#/$defs/")
+	}
+	switch schema.Type {
+	case "boolean":
+		return "boolean"
+	case "integer", "number":
+		return "number"
+	case "string":
+		return "string"
+	case "array":
+		return g.typeName(schema.Items, indent) + "[]"
+	case "object":
+		if schema.Properties == nil {
+			return "Record<string, " + g.typeName(schema.AdditionalProperties, indent) + ">"
+		}
+		return g.objectBody(schema, indent)
+	}
+	return "any"
+}
+
+func (g *tsGenerator) objectBody(schema *cmd.JSONSchema, indent string) string {
+	required := map[string]bool{}
+	for _, name := range schema.Required {
+		required[name] = true
+	}
+
+	var b strings.Builder
+	b.WriteString("{\n")
+	for _, name := range propertyNames(schema) {
+		optional := "?"
+		if required[name] {
+			optional = ""
+		}
+		fmt.Fprintf(&b, "%s  %s%s: %s;\n", indent, name, optional, g.typeName(schema.Properties[name], indent+"  "))
+	}
+	b.WriteString(indent + "}")
+	return b.String()
+}
+
+func (g *tsGenerator) Generate(w io.Writer, doc *cmd.SchemaDoc, routes []route) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "// Code generated by stubgen. DO NOT EDIT.")
+	fmt.Fprintln(bw)
+	fmt.Fprintln(bw, "export interface Reply<T> {\n  code?: string;\n  msg?: string;\n  data?: T;\n}")
+
+	for _, name := range sortedKeys(doc.Defs) {
+		fmt.Fprintln(bw)
+		fmt.Fprintf(bw, "export interface %s %s\n", name, g.objectBody(doc.Defs[name], ""))
+	}
+
+	fmt.Fprintln(bw)
+	fmt.Fprintln(bw, "export const MsgId = {")
+	for _, r := range routes {
+		fmt.Fprintf(bw, "  %s: %q,\n", r.Name, r.MsgId)
+	}
+	fmt.Fprintln(bw, "} as const;")
+
+	fmt.Fprintln(bw)
+	fmt.Fprintln(bw, "export interface Requests {")
+	for _, r := range routes {
+		fmt.Fprintf(bw, "  %q: %s;\n", r.MsgId, g.typeName(r.Args, "  "))
+	}
+	fmt.Fprintln(bw, "}")
+
+	fmt.Fprintln(bw)
+	fmt.Fprintln(bw, "export interface Replies {")
+	// 不同服务的同名回复合并为联合类型
+	var replyIds []string
+	replyTypes := map[string][]string{}
+	for _, r := range routes {
+		if r.ReplyId == "" {
+			continue
+		}
+		if _, ok := replyTypes[r.ReplyId]; !ok {
+			replyIds = append(replyIds, r.ReplyId)
+		}
+		if typeName := g.typeName(r.Reply, "  "); !slices.Contains(replyTypes[r.ReplyId], typeName) {
+			replyTypes[r.ReplyId] = append(replyTypes[r.ReplyId], typeName)
+		}
+	}
+	for _, replyId := range replyIds {
+		fmt.Fprintf(bw, "  %q: Reply<%s>;\n", replyId, strings.Join(replyTypes[replyId], " | "))
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
@@ -0,0 +1,27 @@
+package utils
+
+// 时钟。定时器使用Now获取当前时间，回放消息时替换为录制的时间
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+var clock atomic.Pointer[func() time.Time]
+
+// 当前时间。业务逻辑需回放时使用，替代time.Now
+func Now() time.Time {
+	if now := clock.Load(); now != nil {
+		return (*now)()
+	}
+	return time.Now()
+}
+
+// 设置时钟，为空时恢复为系统时间
+func SetClock(now func() time.Time) {
+	if now == nil {
+		clock.Store(nil)
+		return
+	}
+	clock.Store(&now)
+}
@@ -0,0 +1,55 @@
+package utils
+
+// 令牌桶限流
+
+import (
+	"sync"
+	"time"
+)
+
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒生成的令牌数。不大于0时不限流
+	burst  float64 // 令牌桶容量
+	tokens float64
+	last   time.Time
+}
+
+// 新建的令牌桶是满的
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// 修改限制，保留已有的令牌
+func (b *TokenBucket) SetLimit(rate float64, burst int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rate, b.burst = rate, float64(burst)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+func (b *TokenBucket) Allow() bool {
+	return b.AllowN(time.Now(), 1)
+}
+
+func (b *TokenBucket) AllowN(now time.Time, n int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate <= 0 {
+		return true
+	}
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
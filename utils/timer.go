@@ -69,7 +69,7 @@ func NewTimerSet() *timerSet {
 
 // 批量处理到期的定时器
 func (tm *timerSet) RunOnce() {
-	now := time.Now()
+	now := Now()
 	for i := 0; tm.h.Len() > 0; i++ {
 		top := tm.h[0]
 		// 处理当前的定时器任务时，新创建的任务放到下一个周期再处理
@@ -101,6 +101,14 @@ func (tm *timerSet) RunOnce() {
 	}
 }
 
+// 最近到期的时间，无定时器时返回false
+func (tm *timerSet) NextExpire() (time.Time, bool) {
+	if tm.h.Len() == 0 {
+		return time.Time{}, false
+	}
+	return tm.h[0].t, true
+}
+
 func (tm *timerSet) StopTimer(timer *Timer) {
 	if timer == nil {
 		return
@@ -128,14 +136,14 @@ func (tm *timerSet) ResetTimer(timer *Timer, d time.Duration) {
 		return
 	}
 
-	timer.t = time.Now().Add(d)
+	timer.t = Now().Add(d)
 	heap.Fix(&tm.h, timer.pos)
 }
 
 func (tm *timerSet) NewTimer(f func(), d time.Duration) *Timer {
 	timer := &Timer{
 		f: f,
-		t: time.Now().Add(d),
+		t: Now().Add(d),
 	}
 	heap.Push(&tm.h, timer)
 	return timer
@@ -19,7 +19,7 @@ func SkipPeriodTime3(now, start time.Time, d time.Duration) time.Time {
 }
 
 func SkipPeriodTime(start time.Time, d time.Duration) time.Time {
-	return SkipPeriodTime3(time.Now(), start, d)
+	return SkipPeriodTime3(Now(), start, d)
 }
 
 func InArray(array any, some any) int {
@@ -42,3 +42,19 @@ func TestEqualJSON(t *testing.T) {
 		t.Error("deep equal result expect true")
 	}
 }
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := utils.NewTokenBucket(2, 3)
+	for i := 0; i < 3; i++ {
+		if !b.AllowN(now, 1) {
+			t.Fatalf("token bucket burst %d", i)
+		}
+	}
+	if b.AllowN(now, 1) {
+		t.Error("token bucket allow over burst")
+	}
+	if !b.AllowN(now.Add(500*time.Millisecond), 1) || b.AllowN(now.Add(500*time.Millisecond), 1) {
+		t.Error("token bucket refill rate")
+	}
+}